@@ -0,0 +1,48 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/TIBCOSoftware/apiscout/server/util"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The annotation with the name of the Secret that holds the credentials to get the OpenAPI doc
+const authSecret = "apiscout/authSecret"
+
+// The label that marks a Secret as holding credentials for apiscout, so the annotation can't reference other Secrets
+const credentialsLabel = "apiscout/credentials"
+
+// credentials reads the credentials to fetch the OpenAPI document of an API endpoint from the Secret referenced by
+// the apiscout/authSecret annotation. The Secret must be in the namespace of the API endpoint, carry the
+// apiscout/credentials label and contain either a token, a username and password, or a header and value. When the
+// annotation isn't set, nil is returned.
+func (k *Kubernetes) credentials(ctx context.Context, endpoint Endpoint) (*util.Credentials, error) {
+	name := endpoint.Metadata[authSecret]
+	if len(name) == 0 {
+		return nil, nil
+	}
+
+	secret, err := k.Clientset.CoreV1().Secrets(endpoint.Namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("error while getting secret %s: %s", name, err.Error())
+	}
+	if secret.Labels[credentialsLabel] != "true" {
+		return nil, fmt.Errorf("secret %s doesn't have the label %s=true, so it can't be used for credentials", name, credentialsLabel)
+	}
+
+	credentials := &util.Credentials{
+		Token:    string(secret.Data["token"]),
+		Username: string(secret.Data["username"]),
+		Password: string(secret.Data["password"]),
+		Header:   string(secret.Data["header"]),
+		Value:    string(secret.Data["value"]),
+	}
+	if len(credentials.Token) == 0 && len(credentials.Username) == 0 && len(credentials.Header) == 0 {
+		return nil, fmt.Errorf("secret %s has no token, username and password, or header and value", name)
+	}
+
+	return credentials, nil
+}
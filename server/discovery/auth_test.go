@@ -0,0 +1,84 @@
+package discovery
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFetchWithAuthSecret(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"swagger": "2.0"}`))
+	}))
+	defer server.Close()
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoice-docs", Namespace: "default", Labels: map[string]string{credentialsLabel: "true"}},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	other := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "database", Namespace: "default"},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(secret, other)}
+	endpoint := Endpoint{Name: "ingress-invoices", Namespace: "default", Kind: "Ingress", SpecURL: server.URL, Metadata: map[string]string{}}
+
+	if _, _, err := kube.Fetch(context.Background(), endpoint); err == nil {
+		t.Fatal("Expected an error without credentials")
+	}
+
+	// Secrets that aren't meant for apiscout can't be used
+	endpoint.Metadata[authSecret] = "database"
+	if _, _, err := kube.Fetch(context.Background(), endpoint); err == nil || !strings.Contains(err.Error(), credentialsLabel) {
+		t.Fatalf("Expected an error for a secret without the label, got %v", err)
+	}
+
+	endpoint.Metadata[authSecret] = "invoice-docs"
+	apidoc, _, err := kube.Fetch(context.Background(), endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apidoc != `{"swagger": "2.0"}` {
+		t.Fatalf("Unexpected document %s", apidoc)
+	}
+}
+
+func TestLoadRefCredentials(t *testing.T) {
+	var authorization []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = append(authorization, r.Header.Get("Authorization"))
+		w.Write([]byte(`{"type": "string"}`))
+	})
+	service := httptest.NewServer(handler)
+	defer service.Close()
+	other := httptest.NewServer(handler)
+	defer other.Close()
+
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoice-docs", Namespace: "default", Labels: map[string]string{credentialsLabel: "true"}},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(secret)}
+	endpoint := Endpoint{Name: "ingress-invoices", Namespace: "default", Kind: "Ingress", SpecURL: service.URL + "/openapi.json",
+		Metadata: map[string]string{authSecret: "invoice-docs"}}
+
+	// The credentials are only sent to the host of the document
+	for _, ref := range []string{service.URL + "/schemas/name.json", other.URL + "/schemas/name.json"} {
+		if _, err := kube.LoadRef(context.Background(), endpoint, ref, 1024); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(authorization) != 2 || authorization[0] != "Bearer s3cr3t" || authorization[1] != "" {
+		t.Errorf("Expected the credentials to be sent to the host of the document only, got %v", authorization)
+	}
+}
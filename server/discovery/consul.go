@@ -0,0 +1,227 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// The service meta key in Consul for apiscout to get the OpenAPI doc from
+	consulSwaggerURL = "apiscout-swaggerUrl"
+	// The time a blocking query waits for changes in the catalog
+	consulWait = "5m"
+	// The time to wait before querying again after an error
+	consulBackoff = 10 * time.Second
+)
+
+// Consul is a source that watches the Consul service catalog for services tagged to be indexed
+type Consul struct {
+	// The address of the Consul agent (like http://localhost:8500)
+	Address string
+	// The tag a service must have for apiscout to index it
+	Tag string
+	// The API endpoints currently known from the catalog
+	endpoints map[string]Endpoint
+}
+
+// consulService is a single service instance as returned by the Consul catalog API
+type consulService struct {
+	ServiceName    string
+	ServiceAddress string
+	Address        string
+	ServicePort    int
+	ServiceTags    []string
+	ServiceMeta    map[string]string
+}
+
+// NewConsul creates a new Consul source for the agent at address, indexing services that have the tag
+func NewConsul(address string, tag string) *Consul {
+	return &Consul{
+		Address:   strings.TrimSuffix(address, "/"),
+		Tag:       tag,
+		endpoints: make(map[string]Endpoint),
+	}
+}
+
+// Name returns the name of the source
+func (c *Consul) Name() string {
+	return "consul"
+}
+
+// Watch uses blocking queries on the Consul catalog to keep the API endpoints in sync with the services
+// that are registered in Consul. Errors talking to Consul are logged and retried after a backoff.
+func (c *Consul) Watch(ctx context.Context, events chan<- Event) error {
+	var index uint64
+
+	// Start without known API endpoints, so all services in the catalog are sent as added. A server that watches the
+	// sources again (like a replica that becomes the leader) starts with an empty catalog.
+	c.endpoints = make(map[string]Endpoint)
+
+	for {
+		services, newIndex, err := c.services(ctx, index)
+		if err == nil {
+			err = c.sync(ctx, services, events)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Keep the old index so the next query doesn't block and the catalog is synchronized again
+			log.Printf("Error while synchronizing Consul catalog: %s", err.Error())
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(consulBackoff):
+			}
+			continue
+		}
+
+		// Reset the index in case it goes backwards, as recommended by Consul
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+	}
+}
+
+// sync compares the tagged services in the catalog with the known API endpoints and sends events for all differences
+func (c *Consul) sync(ctx context.Context, services map[string][]string, events chan<- Event) error {
+	current := make(map[string]Endpoint)
+	for name, tags := range services {
+		if !contains(tags, c.Tag) {
+			continue
+		}
+
+		instances, err := c.service(ctx, name)
+		if err != nil {
+			return err
+		}
+
+		for _, instance := range instances {
+			if !contains(instance.ServiceTags, c.Tag) || len(instance.ServiceMeta[consulSwaggerURL]) == 0 {
+				continue
+			}
+			current[name] = c.endpoint(instance)
+			break
+		}
+	}
+
+	for name, endpoint := range current {
+		previous, ok := c.endpoints[name]
+		if !ok {
+			events <- Event{Type: Added, Endpoint: endpoint}
+		} else if !reflect.DeepEqual(previous, endpoint) {
+			events <- Event{Type: Modified, Endpoint: endpoint}
+		}
+	}
+
+	for name, endpoint := range c.endpoints {
+		if _, ok := current[name]; !ok {
+			events <- Event{Type: Deleted, Endpoint: endpoint}
+		}
+	}
+
+	c.endpoints = current
+	return nil
+}
+
+// services performs a blocking query for all services in the catalog and returns them with their tags
+func (c *Consul) services(ctx context.Context, index uint64) (map[string][]string, uint64, error) {
+	query := url.Values{}
+	query.Set("index", strconv.FormatUint(index, 10))
+	query.Set("wait", consulWait)
+
+	var services map[string][]string
+	header, err := c.get(ctx, fmt.Sprintf("%s/v1/catalog/services?%s", c.Address, query.Encode()), &services)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	newIndex, err := strconv.ParseUint(header.Get("X-Consul-Index"), 10, 64)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid X-Consul-Index header: %s", err.Error())
+	}
+
+	return services, newIndex, nil
+}
+
+// service returns all instances of a service registered in the catalog
+func (c *Consul) service(ctx context.Context, name string) ([]consulService, error) {
+	var instances []consulService
+	_, err := c.get(ctx, fmt.Sprintf("%s/v1/catalog/service/%s", c.Address, url.PathEscape(name)), &instances)
+	return instances, err
+}
+
+// get performs an HTTP GET request to the Consul agent and decodes the JSON response into v
+func (c *Consul) get(ctx context.Context, url string, v interface{}) (http.Header, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected response from Consul: %s", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return nil, fmt.Errorf("error while unmarshaling JSON: %s", err.Error())
+	}
+
+	return res.Header, nil
+}
+
+// endpoint translates a Consul service instance into an API endpoint
+func (c *Consul) endpoint(instance consulService) Endpoint {
+	// The service address is optional in Consul, in which case the address of the node is used
+	address := instance.ServiceAddress
+	if len(address) == 0 {
+		address = instance.Address
+	}
+
+	metadata := make(map[string]string)
+	for key, value := range instance.ServiceMeta {
+		metadata[key] = value
+	}
+
+	// Consul doesn't allow slashes in metadata keys, so the ownership and lifecycle are stored under the same keys as the
+	// annotations
+	for _, field := range declaredFields {
+		if value, ok := instance.ServiceMeta["apiscout-"+field]; ok {
+			metadata["apiscout/"+field] = value
+		}
+	}
+
+	return Endpoint{
+		Name:     instance.ServiceName,
+		Source:   c.Name(),
+		SpecURL:  fmt.Sprintf("http://%s:%d%s", address, instance.ServicePort, instance.ServiceMeta[consulSwaggerURL]),
+		Host:     fmt.Sprintf("%s:%d", address, instance.ServicePort),
+		Metadata: metadata,
+	}
+}
+
+// contains checks whether a slice of strings contains the value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConsulSync(t *testing.T) {
+	catalog := map[string][]string{
+		"consul":         {},
+		"invoiceservice": {"apiscout", "v1"},
+	}
+	instances := []consulService{
+		{
+			ServiceName: "invoiceservice",
+			Address:     "10.0.0.1",
+			ServicePort: 8080,
+			ServiceTags: []string{"apiscout", "v1"},
+			ServiceMeta: map[string]string{consulSwaggerURL: "/swaggerspec", "apiscout-team": "invoicing", "apiscout-lifecycle": "Stable"},
+		},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "42")
+		json.NewEncoder(w).Encode(catalog)
+	})
+	mux.HandleFunc("/v1/catalog/service/invoiceservice", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(instances)
+	})
+	consul := httptest.NewServer(mux)
+	defer consul.Close()
+
+	c := NewConsul(consul.URL, "apiscout")
+	ctx := context.Background()
+	events := make(chan Event, 10)
+
+	services, index, err := c.services(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index != 42 {
+		t.Fatalf("Expected index 42, got %d", index)
+	}
+
+	if err := c.sync(ctx, services, events); err != nil {
+		t.Fatal(err)
+	}
+	evt := <-events
+	if evt.Type != Added || evt.Endpoint.SpecURL != "http://10.0.0.1:8080/swaggerspec" {
+		t.Fatalf("Unexpected event %+v", evt)
+	}
+	if ownership := evt.Endpoint.Ownership(); ownership.Team != "invoicing" || ownership.Lifecycle != "stable" {
+		t.Fatalf("Unexpected ownership %+v", ownership)
+	}
+
+	// Nothing changed, so no events should be sent
+	if err := c.sync(ctx, services, events); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+
+	// The service lost its tag, so it should be removed
+	delete(catalog, "invoiceservice")
+	services, _, err = c.services(ctx, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := c.sync(ctx, services, events); err != nil {
+		t.Fatal(err)
+	}
+	evt = <-events
+	if evt.Type != Deleted || evt.Endpoint.Name != "invoiceservice" {
+		t.Fatalf("Unexpected event %+v", evt)
+	}
+}
+
+func TestConsulWatchAgain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/catalog/services", func(w http.ResponseWriter, r *http.Request) {
+		// Block like a blocking query once the catalog was returned
+		if r.URL.Query().Get("index") == "42" {
+			<-r.Context().Done()
+			return
+		}
+		w.Header().Set("X-Consul-Index", "42")
+		json.NewEncoder(w).Encode(map[string][]string{"invoiceservice": {"apiscout"}})
+	})
+	mux.HandleFunc("/v1/catalog/service/invoiceservice", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode([]consulService{{
+			ServiceName: "invoiceservice",
+			Address:     "10.0.0.1",
+			ServicePort: 8080,
+			ServiceTags: []string{"apiscout"},
+			ServiceMeta: map[string]string{consulSwaggerURL: "/swaggerspec"},
+		}})
+	})
+	consul := httptest.NewServer(mux)
+	defer consul.Close()
+
+	// Every watch (like the one of a new leader) sends the services in the catalog as added
+	c := NewConsul(consul.URL, "apiscout")
+	for i := 0; i < 2; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan Event, 10)
+		done := make(chan error)
+		go func() {
+			done <- c.Watch(ctx, events)
+		}()
+		select {
+		case evt := <-events:
+			if evt.Type != Added || evt.Endpoint.Name != "invoiceservice" {
+				t.Fatalf("Unexpected event %+v in watch %d", evt, i+1)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected the service to be added in watch %d", i+1)
+		}
+		cancel()
+		<-done
+	}
+}
@@ -0,0 +1,208 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/util/retry"
+)
+
+// The kind of the custom resource to declare APIs
+const apiKind = "ApiScoutAPI"
+
+// The ApiScoutAPI custom resource
+var apiResource = schema.GroupVersionResource{Group: "apiscout.tibco.com", Version: "v1alpha1", Resource: "apiscoutapis"}
+
+// handleAPI reconciles an ApiScoutAPI resource into an API endpoint event. Only changes to the spec of the resource
+// (which change its generation) cause the API to be indexed again, so status updates don't trigger a new fetch.
+func (k *Kubernetes) handleAPI(ctx context.Context, eventType watch.EventType, api *unstructured.Unstructured, events chan<- Event) {
+	log.Printf("Received %s for %s %s in %s\n", eventType, apiKind, api.GetName(), k.Name())
+
+	key := api.GetNamespace() + "/" + api.GetName()
+
+	switch eventType {
+	case watch.Added, watch.Modified:
+		k.mu.Lock()
+		generation, seen := k.generations[key]
+		k.generations[key] = api.GetGeneration()
+		k.mu.Unlock()
+		if eventType == watch.Modified && seen && generation == api.GetGeneration() {
+			return
+		}
+
+		endpoint, err := k.apiEndpoint(ctx, api)
+		if err != nil {
+			log.Printf("Error while resolving %s %s: %s", apiKind, api.GetName(), err.Error())
+			k.reportAPIStatus(ctx, endpoint, Status{Result: FetchFailed, Message: err.Error()})
+			return
+		}
+
+		if eventType == watch.Modified {
+			events <- Event{Type: Modified, Endpoint: endpoint}
+		} else {
+			events <- Event{Type: Added, Endpoint: endpoint}
+		}
+	case watch.Deleted:
+		k.mu.Lock()
+		delete(k.generations, key)
+		k.mu.Unlock()
+		events <- Event{Type: Deleted, Endpoint: k.apiReference(api)}
+	default:
+		log.Printf("Received unknown watch.EventType %s, so API Scout will ignore\n", eventType)
+	}
+}
+
+// apiReference returns the API endpoint for an ApiScoutAPI resource, without resolving where to fetch it from
+func (k *Kubernetes) apiReference(api *unstructured.Unstructured) Endpoint {
+	metadata := make(map[string]string)
+	for key, value := range api.GetAnnotations() {
+		metadata[key] = value
+	}
+
+	// Store the declared metadata under the same keys as the annotations
+	tags, _, _ := unstructured.NestedStringSlice(api.Object, "spec", "tags")
+	if len(tags) > 0 {
+		metadata[tagsAnnotation] = strings.Join(tags, ",")
+	}
+	for _, field := range append(declaredFields, "visibility") {
+		if value, _, _ := unstructured.NestedString(api.Object, "spec", field); len(value) > 0 {
+			metadata["apiscout/"+field] = value
+		}
+	}
+
+	return Endpoint{
+		Name:      api.GetName(),
+		Source:    k.Name(),
+		Cluster:   k.Cluster,
+		Namespace: api.GetNamespace(),
+		Kind:      apiKind,
+		Object:    api.GetName(),
+		Metadata:  metadata,
+		Labels:    copyLabels(api.GetLabels()),
+	}
+}
+
+// apiEndpoint resolves the API endpoint for an ApiScoutAPI resource. The source is either an absolute URL or a path
+// on the owning service of the API.
+func (k *Kubernetes) apiEndpoint(ctx context.Context, api *unstructured.Unstructured) (Endpoint, error) {
+	endpoint := k.apiReference(api)
+
+	source, _, _ := unstructured.NestedString(api.Object, "spec", "source")
+	serviceName, _, _ := unstructured.NestedString(api.Object, "spec", "service")
+
+	if len(serviceName) == 0 {
+		u, err := url.Parse(source)
+		if err != nil || !u.IsAbs() {
+			return endpoint, fmt.Errorf("source %s must be an absolute URL when no service is specified", source)
+		}
+		endpoint.SpecURL = source
+		endpoint.Host = u.Host
+		return endpoint, nil
+	}
+
+	service, err := k.Clientset.CoreV1().Services(api.GetNamespace()).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return endpoint, fmt.Errorf("error while getting service %s: %s", serviceName, err.Error())
+	}
+
+	public := k.publicEndpoint(ctx, service)
+	endpoint.SpecURL = fmt.Sprintf("http://%s%s", k.address(service), source)
+	endpoint.Host = public.Host
+	endpoint.PublicURL = public.PublicURL
+	endpoint.Workload = public.Workload
+	endpoint.Image = public.Image
+
+	return endpoint, nil
+}
+
+// reportAPIStatus writes the status of indexing as conditions to the ApiScoutAPI resource the API endpoint was
+// discovered from
+func (k *Kubernetes) reportAPIStatus(ctx context.Context, endpoint Endpoint, status Status) {
+	if k.Dynamic == nil {
+		return
+	}
+
+	conditions := apiConditions(status)
+	client := k.Dynamic.Resource(apiResource).Namespace(endpoint.Namespace)
+
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		api, err := client.Get(ctx, endpoint.Object, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		// Merge the new conditions with the existing conditions of the resource
+		var existing []metav1.Condition
+		if raw, found, _ := unstructured.NestedSlice(api.Object, "status", "conditions"); found {
+			b, _ := json.Marshal(raw)
+			json.Unmarshal(b, &existing)
+		}
+		for _, condition := range conditions {
+			condition.ObservedGeneration = api.GetGeneration()
+			meta.SetStatusCondition(&existing, condition)
+		}
+
+		var raw []interface{}
+		b, err := json.Marshal(existing)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(b, &raw); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedSlice(api.Object, raw, "status", "conditions"); err != nil {
+			return err
+		}
+		if err := unstructured.SetNestedField(api.Object, api.GetGeneration(), "status", "observedGeneration"); err != nil {
+			return err
+		}
+
+		_, err = client.UpdateStatus(ctx, api, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		log.Printf("Error while updating status of %s %s: %s", apiKind, endpoint.Object, err.Error())
+	}
+}
+
+// apiConditions translates the status of indexing into the Indexed, FetchFailed and Valid conditions
+func apiConditions(status Status) []metav1.Condition {
+	message := status.Message
+	if len(message) == 0 {
+		message = string(status.Result)
+	}
+
+	switch status.Result {
+	case Indexed:
+		valid := metav1.Condition{Type: "Valid", Status: metav1.ConditionTrue, Reason: "Valid", Message: "The OpenAPI document is valid"}
+		if len(status.Problems) > 0 {
+			valid = metav1.Condition{Type: "Valid", Status: metav1.ConditionFalse, Reason: "ValidationProblems", Message: strings.Join(status.Problems, "; ")}
+		}
+		return []metav1.Condition{
+			{Type: "Indexed", Status: metav1.ConditionTrue, Reason: "Indexed", Message: message},
+			{Type: "FetchFailed", Status: metav1.ConditionFalse, Reason: "Fetched", Message: "The OpenAPI document was fetched"},
+			valid,
+		}
+	case FetchFailed:
+		return []metav1.Condition{
+			{Type: "Indexed", Status: metav1.ConditionFalse, Reason: "FetchFailed", Message: message},
+			{Type: "FetchFailed", Status: metav1.ConditionTrue, Reason: "FetchFailed", Message: message},
+		}
+	default:
+		return []metav1.Condition{
+			{Type: "Indexed", Status: metav1.ConditionFalse, Reason: string(status.Result), Message: message},
+			{Type: "FetchFailed", Status: metav1.ConditionFalse, Reason: "Fetched", Message: "The OpenAPI document was fetched"},
+			{Type: "Valid", Status: metav1.ConditionFalse, Reason: string(status.Result), Message: message},
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// newAPI returns an ApiScoutAPI resource with the spec
+func newAPI(name string, generation int64, spec map[string]interface{}) *unstructured.Unstructured {
+	api := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": apiResource.GroupVersion().String(),
+		"kind":       apiKind,
+		"metadata":   map[string]interface{}{"name": name, "namespace": "billing", "generation": generation},
+		"spec":       spec,
+	}}
+	return api
+}
+
+// newDynamicClient returns a fake dynamic client that knows the ApiScoutAPI resources and the Gateway API resources
+func newDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	scheme := runtime.NewScheme()
+	scheme.AddKnownTypeWithName(schema.GroupVersionKind{Group: apiResource.Group, Version: apiResource.Version, Kind: apiKind}, &unstructured.Unstructured{})
+	listKinds := map[schema.GroupVersionResource]string{
+		apiResource:       apiKind + "List",
+		httpRouteResource: "HTTPRouteList",
+		gatewayResource:   "GatewayList",
+	}
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(scheme, listKinds, objects...)
+}
+
+// apiConditionsOf returns the conditions in the status of the ApiScoutAPI resource, by type
+func apiConditionsOf(t *testing.T, k *Kubernetes, name string) map[string]metav1.Condition {
+	api, err := k.Dynamic.Resource(apiResource).Namespace("billing").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, _, _ := unstructured.NestedSlice(api.Object, "status", "conditions")
+	var conditions []metav1.Condition
+	b, _ := json.Marshal(raw)
+	json.Unmarshal(b, &conditions)
+
+	byType := make(map[string]metav1.Condition)
+	for _, condition := range conditions {
+		byType[condition.Type] = condition
+	}
+	return byType
+}
+
+func TestHandleAPI(t *testing.T) {
+	api := newAPI("invoices", 1, map[string]interface{}{
+		"source": "https://api.example.com/invoices/openapi.json",
+		"tags":   []interface{}{"billing", "invoices"},
+		"owner":  "Jane",
+	})
+	invalid := newAPI("reports", 1, map[string]interface{}{"source": "/openapi.json"})
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(), Dynamic: newDynamicClient(api, invalid), generations: make(map[string]int64)}
+	ctx := context.Background()
+	events := make(chan Event, 1)
+
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: api}, events)
+	evt := <-events
+	if evt.Type != Added || evt.Endpoint.Kind != apiKind || evt.Endpoint.Object != "invoices" || evt.Endpoint.Namespace != "billing" {
+		t.Fatalf("Unexpected event %+v", evt)
+	}
+	if evt.Endpoint.SpecURL != "https://api.example.com/invoices/openapi.json" || evt.Endpoint.Host != "api.example.com" {
+		t.Errorf("Unexpected spec URL %s and host %s", evt.Endpoint.SpecURL, evt.Endpoint.Host)
+	}
+	if evt.Endpoint.Metadata[tagsAnnotation] != "billing,invoices" || evt.Endpoint.Metadata[ownerAnnotation] != "Jane" {
+		t.Errorf("Unexpected metadata %+v", evt.Endpoint.Metadata)
+	}
+
+	// Status updates don't change the generation, so they don't cause the API to be indexed again
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: api}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+	api.SetGeneration(2)
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: api}, events)
+	if evt := <-events; evt.Type != Modified {
+		t.Fatalf("Expected %s event, got %s", Modified, evt.Type)
+	}
+
+	kube.handleEvent(ctx, watch.Event{Type: watch.Deleted, Object: api}, events)
+	if evt := <-events; evt.Type != Deleted || evt.Endpoint.Object != "invoices" {
+		t.Fatalf("Unexpected event %+v", evt)
+	}
+
+	// A relative source without a service can't be fetched, which is recorded in the status of the resource
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: invalid}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+	if condition := apiConditionsOf(t, kube, "reports")["FetchFailed"]; condition.Status != metav1.ConditionTrue {
+		t.Errorf("Expected the FetchFailed condition to be true, got %+v", condition)
+	}
+}
+
+func TestAPIEndpoint(t *testing.T) {
+	service := &v1.Service{}
+	json.Unmarshal([]byte(kubeServicePayload), service)
+	service.Namespace = "billing"
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(service)}
+	api := newAPI("invoices", 1, map[string]interface{}{"source": "/openapi.json", "service": "invoice-go-svc"})
+	endpoint, err := kube.apiEndpoint(context.Background(), api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if endpoint.SpecURL != "http://10.99.164.156:80/openapi.json" || endpoint.Host != "10.99.164.156:80" {
+		t.Errorf("Unexpected spec URL %s and host %s", endpoint.SpecURL, endpoint.Host)
+	}
+
+	if endpoint.Internal() {
+		t.Error("Expected the API to be public")
+	}
+
+	// The visibility of the resource decides whether the API is internal
+	api = newAPI("invoices", 1, map[string]interface{}{"source": "/openapi.json", "service": "invoice-go-svc", "visibility": "internal"})
+	if endpoint, err := kube.apiEndpoint(context.Background(), api); err != nil || !endpoint.Internal() {
+		t.Errorf("Expected the API to be internal, got %v", err)
+	}
+
+	// The service must exist in the namespace of the resource
+	api = newAPI("invoices", 1, map[string]interface{}{"source": "/openapi.json", "service": "unknown"})
+	if _, err := kube.apiEndpoint(context.Background(), api); err == nil {
+		t.Error("Expected an error for an unknown service")
+	}
+}
+
+func TestReportAPIStatus(t *testing.T) {
+	api := newAPI("invoices", 3, map[string]interface{}{"source": "https://api.example.com/openapi.json"})
+	kube := &Kubernetes{Dynamic: newDynamicClient(api)}
+	endpoint := Endpoint{Name: "invoices", Kind: apiKind, Namespace: "billing", Object: "invoices"}
+
+	kube.ReportStatus(context.Background(), endpoint, Status{Result: Indexed, Message: "Indexed", Problems: []string{"info: missing version"}})
+	conditions := apiConditionsOf(t, kube, "invoices")
+	if indexed := conditions["Indexed"]; indexed.Status != metav1.ConditionTrue || indexed.ObservedGeneration != 3 {
+		t.Errorf("Unexpected Indexed condition %+v", indexed)
+	}
+	if valid := conditions["Valid"]; valid.Status != metav1.ConditionFalse || valid.Message != "info: missing version" {
+		t.Errorf("Unexpected Valid condition %+v", valid)
+	}
+
+	// A later failure replaces the conditions of the same type
+	kube.ReportStatus(context.Background(), endpoint, Status{Result: FetchFailed, Message: "connection refused"})
+	conditions = apiConditionsOf(t, kube, "invoices")
+	if indexed := conditions["Indexed"]; indexed.Status != metav1.ConditionFalse || indexed.Message != "connection refused" {
+		t.Errorf("Unexpected Indexed condition %+v", indexed)
+	}
+	if fetchFailed := conditions["FetchFailed"]; fetchFailed.Status != metav1.ConditionTrue {
+		t.Errorf("Unexpected FetchFailed condition %+v", fetchFailed)
+	}
+}
+
+func TestAPIConditions(t *testing.T) {
+	for _, test := range []struct {
+		status   Status
+		expected map[string]metav1.ConditionStatus
+	}{
+		{Status{Result: Indexed}, map[string]metav1.ConditionStatus{"Indexed": metav1.ConditionTrue, "FetchFailed": metav1.ConditionFalse, "Valid": metav1.ConditionTrue}},
+		{Status{Result: Indexed, Problems: []string{"invalid"}}, map[string]metav1.ConditionStatus{"Indexed": metav1.ConditionTrue, "FetchFailed": metav1.ConditionFalse, "Valid": metav1.ConditionFalse}},
+		{Status{Result: FetchFailed}, map[string]metav1.ConditionStatus{"Indexed": metav1.ConditionFalse, "FetchFailed": metav1.ConditionTrue}},
+		{Status{Result: InvalidSpec}, map[string]metav1.ConditionStatus{"Indexed": metav1.ConditionFalse, "FetchFailed": metav1.ConditionFalse, "Valid": metav1.ConditionFalse}},
+	} {
+		conditions := apiConditions(test.status)
+		if len(conditions) != len(test.expected) {
+			t.Errorf("Expected %d conditions for %+v, got %+v", len(test.expected), test.status, conditions)
+		}
+		for _, condition := range conditions {
+			if condition.Status != test.expected[condition.Type] || len(condition.Reason) == 0 || len(condition.Message) == 0 {
+				t.Errorf("Unexpected condition %+v for %+v", condition, test.status)
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TIBCOSoftware/apiscout/server/util"
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// SelectAll fetches the OpenAPI document from every ready pod and detects pods serving different versions
+	SelectAll = "ALL"
+	// SelectFirst fetches the OpenAPI document from the first ready pod only
+	SelectFirst = "FIRST"
+)
+
+// fetchEndpoints fetches the OpenAPI document of an API endpoint from the ready pods behind the service. When the
+// pods serve different documents (like during a rollout), the document served by most pods is returned together
+// with a warning.
+func (k *Kubernetes) fetchEndpoints(ctx context.Context, endpoint Endpoint, credentials *util.Credentials) (string, []string, error) {
+	service, specURL, err := k.proxyTarget(ctx, endpoint)
+	if err != nil {
+		return "", nil, err
+	}
+	if service == nil {
+		apidoc, err := util.GetAPIDoc(ctx, endpoint.SpecURL, credentials, util.MaxAPIDocSize)
+		return apidoc, nil, err
+	}
+	if len(service.Spec.Ports) == 0 {
+		return "", nil, fmt.Errorf("service %s has no ports to fetch the OpenAPI document from", service.Name)
+	}
+
+	addresses, err := k.podAddresses(ctx, service, service.Spec.Ports[0])
+	if err != nil {
+		return "", nil, err
+	}
+	if len(addresses) == 0 {
+		return "", nil, fmt.Errorf("no endpoints available for service %s", service.Name)
+	}
+	if strings.ToUpper(k.EndpointSelection) == SelectFirst {
+		addresses = addresses[:1]
+	}
+
+	// Fetch the document from each pod and group the pods by the document they serve
+	documents := make(map[string]string)
+	pods := make(map[string]int)
+	var failures []string
+	for _, address := range addresses {
+		apidoc, err := util.GetAPIDoc(ctx, fmt.Sprintf("http://%s%s", address, specURL), credentials, util.MaxAPIDocSize)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		hash := util.Hash(apidoc)
+		documents[hash] = apidoc
+		pods[hash]++
+	}
+	if len(documents) == 0 {
+		return "", nil, fmt.Errorf("error while fetching from the endpoints of service %s: %s", service.Name, strings.Join(failures, "; "))
+	}
+
+	// Publish the document served by most pods, using the hash to break ties so the choice is stable
+	majority := ""
+	for hash := range documents {
+		if len(majority) == 0 || pods[hash] > pods[majority] || (pods[hash] == pods[majority] && hash < majority) {
+			majority = hash
+		}
+	}
+
+	var warnings []string
+	if len(documents) > 1 {
+		log.Printf("Pods of service %s serve %d different OpenAPI documents", service.Name, len(documents))
+		warnings = append(warnings, fmt.Sprintf("Rollout in progress: the pods of this service serve %d different versions of the API. This page shows the version served by %d of %d pods.", len(documents), pods[majority], len(addresses)-len(failures)))
+	}
+	if len(failures) > 0 {
+		log.Printf("Error while fetching from %d endpoints of service %s: %s", len(failures), service.Name, strings.Join(failures, "; "))
+		warnings = append(warnings, fmt.Sprintf("The OpenAPI document could not be fetched from %d of %d pods of this service.", len(failures), len(addresses)))
+	}
+
+	return documents[majority], warnings, nil
+}
+
+// podAddresses returns the sorted addresses (ip:port) of the ready pods behind the port of the service, using the
+// EndpointSlices of the service
+func (k *Kubernetes) podAddresses(ctx context.Context, service *v1.Service, servicePort v1.ServicePort) ([]string, error) {
+	slices, err := k.Clientset.DiscoveryV1().EndpointSlices(service.Namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: discoveryv1.LabelServiceName + "=" + service.Name,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error while listing EndpointSlices of service %s: %s", service.Name, err.Error())
+	}
+
+	seen := make(map[string]bool)
+	var addresses []string
+	for _, slice := range slices.Items {
+		port := slicePort(slice, servicePort)
+		if port == 0 {
+			continue
+		}
+		for _, ep := range slice.Endpoints {
+			// Endpoints without a ready condition should be considered ready
+			if ep.Conditions.Ready != nil && !*ep.Conditions.Ready {
+				continue
+			}
+			for _, ip := range ep.Addresses {
+				address := net.JoinHostPort(ip, strconv.Itoa(int(port)))
+				if !seen[address] {
+					seen[address] = true
+					addresses = append(addresses, address)
+				}
+			}
+		}
+	}
+
+	sort.Strings(addresses)
+	return addresses, nil
+}
+
+// slicePort returns the port number in the EndpointSlice for the port of the service, matched by name
+func slicePort(slice discoveryv1.EndpointSlice, servicePort v1.ServicePort) int32 {
+	for _, port := range slice.Ports {
+		name := ""
+		if port.Name != nil {
+			name = *port.Name
+		}
+		if name == servicePort.Name && port.Port != nil {
+			return *port.Port
+		}
+	}
+	return 0
+}
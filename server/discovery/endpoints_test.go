@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestFetchEndpoints(t *testing.T) {
+	// Three pods of which one still serves the old version of the API
+	var slices []*discoveryv1.EndpointSlice
+	for i, doc := range []string{`{"swagger":"2.0","info":{"version":"2"}}`, `{"swagger":"2.0","info":{"version":"1"}}`, `{"swagger":"2.0","info":{"version":"2"}}`} {
+		doc := doc
+		pod := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(doc))
+		}))
+		defer pod.Close()
+
+		host, portValue, _ := net.SplitHostPort(pod.Listener.Addr().String())
+		port, _ := strconv.Atoi(portValue)
+		port32 := int32(port)
+		name := ""
+		slices = append(slices, &discoveryv1.EndpointSlice{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "invoice-go-svc-" + strconv.Itoa(i),
+				Namespace: "default",
+				Labels:    map[string]string{discoveryv1.LabelServiceName: "invoice-go-svc"},
+			},
+			Endpoints: []discoveryv1.Endpoint{{Addresses: []string{host}}},
+			Ports:     []discoveryv1.EndpointPort{{Name: &name, Port: &port32}},
+		})
+	}
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoice-go-svc", Namespace: "default"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 80}}},
+	}
+	clientset := fake.NewSimpleClientset(service)
+	for _, slice := range slices {
+		clientset.DiscoveryV1().EndpointSlices("default").Create(context.Background(), slice, metav1.CreateOptions{})
+	}
+
+	k := &Kubernetes{Clientset: clientset, FetchMode: FetchEndpoints, EndpointSelection: SelectAll}
+	endpoint := Endpoint{Name: "invoice-go-svc", Namespace: "default", Kind: "Service", Object: "invoice-go-svc", Metadata: map[string]string{swaggerURL: "/swaggerspec"}}
+
+	apidoc, warnings, err := k.Fetch(context.Background(), endpoint)
+	if err != nil {
+		t.Fatalf("Fetch returned an error: %s", err.Error())
+	}
+	if apidoc != `{"swagger":"2.0","info":{"version":"2"}}` {
+		t.Errorf("Fetch returned %s, expected the version served by most pods", apidoc)
+	}
+	if len(warnings) != 1 {
+		t.Errorf("Fetch returned %d warnings, expected a warning about the rollout", len(warnings))
+	}
+
+	// Only the first pod is used when selecting the first endpoint, so no skew is detected
+	k.EndpointSelection = SelectFirst
+	_, warnings, err = k.Fetch(context.Background(), endpoint)
+	if err != nil {
+		t.Fatalf("Fetch returned an error: %s", err.Error())
+	}
+	if len(warnings) != 0 {
+		t.Errorf("Fetch returned %d warnings, expected none", len(warnings))
+	}
+}
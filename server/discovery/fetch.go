@@ -0,0 +1,175 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/TIBCOSoftware/apiscout/server/util"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilnet "k8s.io/apimachinery/pkg/util/net"
+)
+
+const (
+	// FetchDirect fetches OpenAPI documents directly from the services
+	FetchDirect = "DIRECT"
+	// FetchProxy fetches OpenAPI documents through the services/proxy subresource of the Kubernetes API server
+	FetchProxy = "PROXY"
+	// FetchEndpoints fetches OpenAPI documents from the ready pods behind the services, using their EndpointSlices
+	FetchEndpoints = "ENDPOINTS"
+)
+
+// Fetch returns the OpenAPI document of an API endpoint. When the FetchMode is PROXY, documents of services are
+// fetched through the Kubernetes API server, so apiscout doesn't need to be able to reach the services itself. When
+// the FetchMode is ENDPOINTS, documents are fetched from the ready pods behind the service.
+//
+// When the apiscout/authSecret annotation references a Secret, the credentials in the Secret are used to fetch
+// the document.
+func (k *Kubernetes) Fetch(ctx context.Context, endpoint Endpoint) (string, []string, error) {
+	credentials, err := k.credentials(ctx, endpoint)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if endpoint.Kind == "Service" || endpoint.Kind == apiKind {
+		switch strings.ToUpper(k.FetchMode) {
+		case FetchProxy:
+			apidoc, err := k.fetchProxy(ctx, endpoint, credentials, "", util.MaxAPIDocSize)
+			return apidoc, nil, err
+		case FetchEndpoints:
+			return k.fetchEndpoints(ctx, endpoint, credentials)
+		}
+	}
+
+	apidoc, err := util.GetAPIDoc(ctx, endpoint.SpecURL, credentials, util.MaxAPIDocSize)
+	return apidoc, nil, err
+}
+
+// LoadRef loads a file referenced by the OpenAPI document of an API endpoint. Files on the same scheme and host as
+// the document are loaded with the same credentials as the document, and redirects to another scheme or host are
+// refused for them. Files elsewhere are loaded without credentials. When the FetchMode is PROXY, files on the service
+// itself are loaded through the Kubernetes API server.
+func (k *Kubernetes) LoadRef(ctx context.Context, endpoint Endpoint, ref string, maxSize int) (string, error) {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing %s: %s", ref, err.Error())
+	}
+	spec, err := url.Parse(endpoint.SpecURL)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing %s: %s", endpoint.SpecURL, err.Error())
+	}
+	if u.Scheme != spec.Scheme || !strings.EqualFold(u.Host, spec.Host) {
+		return util.GetAPIDoc(ctx, ref, nil, maxSize)
+	}
+
+	credentials, err := k.credentials(ctx, endpoint)
+	if err != nil {
+		return "", err
+	}
+	if strings.ToUpper(k.FetchMode) == FetchProxy && (endpoint.Kind == "Service" || endpoint.Kind == apiKind) {
+		return k.fetchProxy(ctx, endpoint, credentials, ref, maxSize)
+	}
+
+	return util.GetAPIDoc(ctx, ref, credentials, maxSize)
+}
+
+// fetchProxy fetches the OpenAPI document of an API endpoint, or the file at target on the same service when target
+// isn't empty, through the services/proxy subresource of the Kubernetes API server. The Authorization header is used
+// to authenticate to the API server itself, so only credentials that are sent in another header can be passed on to
+// the service. At most maxSize bytes are read.
+func (k *Kubernetes) fetchProxy(ctx context.Context, endpoint Endpoint, credentials *util.Credentials, target string, maxSize int) (string, error) {
+	service, specURL, err := k.proxyTarget(ctx, endpoint)
+	if err != nil {
+		return "", err
+	}
+	if len(target) == 0 {
+		target = endpoint.SpecURL
+	} else {
+		specURL = target
+	}
+	if service == nil {
+		return util.GetAPIDoc(ctx, target, credentials, maxSize)
+	}
+	if credentials != nil && (len(credentials.Token) > 0 || len(credentials.Username) > 0) {
+		return "", fmt.Errorf("the credentials of %s can't be sent through the API server, only an API key header can", endpoint.Name)
+	}
+	if len(service.Spec.Ports) == 0 {
+		return "", fmt.Errorf("service %s has no ports to fetch the OpenAPI document from", service.Name)
+	}
+
+	// Split the query parameters from the path
+	u, err := url.Parse(specURL)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing %s: %s", specURL, err.Error())
+	}
+	params := make(map[string]string)
+	for key, values := range u.Query() {
+		params[key] = values[0]
+	}
+
+	port := service.Spec.Ports[0].Name
+	if len(port) == 0 {
+		port = strconv.Itoa(int(service.Spec.Ports[0].Port))
+	}
+
+	var stream io.ReadCloser
+	if credentials == nil {
+		stream, err = k.Clientset.CoreV1().Services(service.Namespace).ProxyGet("", service.Name, port, u.Path, params).Stream(ctx)
+	} else {
+		request := k.Clientset.CoreV1().RESTClient().Get().
+			Namespace(service.Namespace).
+			Resource("services").
+			SubResource("proxy").
+			Name(utilnet.JoinSchemeNamePort("", service.Name, port)).
+			Suffix(u.Path).
+			SetHeader(credentials.Header, credentials.Value)
+		for key, value := range params {
+			request = request.Param(key, value)
+		}
+		stream, err = request.Stream(ctx)
+	}
+	if err != nil {
+		return "", fmt.Errorf("error while fetching %s through the API server: %s", specURL, err.Error())
+	}
+	defer stream.Close()
+
+	body, err := util.ReadLimited(stream, specURL, maxSize)
+	if err != nil {
+		return "", fmt.Errorf("error while fetching %s through the API server: %s", specURL, err.Error())
+	}
+
+	return string(body), nil
+}
+
+// proxyTarget returns the service and the path on that service from where to read the OpenAPI document of an API
+// endpoint. When the API endpoint doesn't belong to a service (like an ApiScoutAPI with an absolute URL), the
+// service is nil.
+func (k *Kubernetes) proxyTarget(ctx context.Context, endpoint Endpoint) (*v1.Service, string, error) {
+	serviceName := endpoint.Object
+	specURL := endpoint.Metadata[swaggerURL]
+
+	if endpoint.Kind == apiKind {
+		api, err := k.Dynamic.Resource(apiResource).Namespace(endpoint.Namespace).Get(ctx, endpoint.Object, metav1.GetOptions{})
+		if err != nil {
+			return nil, "", fmt.Errorf("error while getting %s %s: %s", apiKind, endpoint.Object, err.Error())
+		}
+		serviceName, _, _ = unstructured.NestedString(api.Object, "spec", "service")
+		specURL, _, _ = unstructured.NestedString(api.Object, "spec", "source")
+		if len(serviceName) == 0 {
+			return nil, "", nil
+		}
+	}
+
+	service, err := k.Clientset.CoreV1().Services(endpoint.Namespace).Get(ctx, serviceName, metav1.GetOptions{})
+	if err != nil {
+		return nil, "", fmt.Errorf("error while getting service %s: %s", serviceName, err.Error())
+	}
+
+	return service, specURL, nil
+}
@@ -0,0 +1,155 @@
+package discovery
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	restclient "k8s.io/client-go/rest"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// proxyResponse is the response of the fake API server to a request through the services/proxy subresource
+type proxyResponse struct {
+	body string
+}
+
+func (r proxyResponse) DoRaw(ctx context.Context) ([]byte, error) {
+	return []byte(r.body), nil
+}
+
+func (r proxyResponse) Stream(ctx context.Context) (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBufferString(r.body)), nil
+}
+
+// newProxyClientset returns a fake clientset with the objects that records the requests through the services/proxy
+// subresource
+func newProxyClientset(requests *[]k8stesting.ProxyGetAction, objects ...runtime.Object) *fake.Clientset {
+	clientset := fake.NewSimpleClientset(objects...)
+	clientset.PrependProxyReactor("services", func(action k8stesting.Action) (bool, restclient.ResponseWrapper, error) {
+		*requests = append(*requests, action.(k8stesting.ProxyGetAction))
+		return true, proxyResponse{body: `{"swagger": "2.0"}`}, nil
+	})
+	return clientset
+}
+
+func TestFetchProxy(t *testing.T) {
+	named := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoices", Namespace: "billing"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	numbered := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "reports", Namespace: "billing"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Port: 8080}}},
+	}
+	portless := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "payments", Namespace: "billing"}}
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoice-docs", Namespace: "billing", Labels: map[string]string{credentialsLabel: "true"}},
+		Data:       map[string][]byte{"token": []byte("s3cr3t")},
+	}
+
+	var requests []k8stesting.ProxyGetAction
+	kube := &Kubernetes{Clientset: newProxyClientset(&requests, named, numbered, portless, secret), FetchMode: FetchProxy}
+	ctx := context.Background()
+
+	// The query parameters are split from the path, and the named port is used
+	endpoint := Endpoint{Name: "invoices", Kind: "Service", Namespace: "billing", Object: "invoices",
+		Metadata: map[string]string{swaggerURL: "/openapi.json?format=json&version=2"}}
+	apidoc, _, err := kube.Fetch(ctx, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apidoc != `{"swagger": "2.0"}` || len(requests) != 1 {
+		t.Fatalf("Unexpected document %s after %d requests", apidoc, len(requests))
+	}
+	if request := requests[0]; request.GetName() != "invoices" || request.GetPort() != "http" || request.GetPath() != "/openapi.json" ||
+		!reflect.DeepEqual(request.GetParams(), map[string]string{"format": "json", "version": "2"}) {
+		t.Errorf("Unexpected request %+v", request)
+	}
+
+	// Ports without a name are referenced by their number
+	endpoint = Endpoint{Name: "reports", Kind: "Service", Namespace: "billing", Object: "reports", Metadata: map[string]string{swaggerURL: "/openapi.json"}}
+	if _, _, err := kube.Fetch(ctx, endpoint); err != nil {
+		t.Fatal(err)
+	}
+	if request := requests[1]; request.GetPort() != "8080" || len(request.GetParams()) != 0 {
+		t.Errorf("Unexpected request %+v", request)
+	}
+
+	// A service without ports can't be fetched
+	endpoint = Endpoint{Name: "payments", Kind: "Service", Namespace: "billing", Object: "payments", Metadata: map[string]string{swaggerURL: "/openapi.json"}}
+	if _, _, err := kube.Fetch(ctx, endpoint); err == nil || !strings.Contains(err.Error(), "has no ports") {
+		t.Errorf("Expected an error for a service without ports, got %v", err)
+	}
+
+	// The Authorization header authenticates to the API server, so a token can't be passed on to the service
+	endpoint = Endpoint{Name: "invoices", Kind: "Service", Namespace: "billing", Object: "invoices",
+		Metadata: map[string]string{swaggerURL: "/openapi.json", authSecret: "invoice-docs"}}
+	if _, _, err := kube.Fetch(ctx, endpoint); err == nil || !strings.Contains(err.Error(), "only an API key header can") {
+		t.Errorf("Expected an error for a token, got %v", err)
+	}
+	if len(requests) != 2 {
+		t.Errorf("Expected no request with a token, got %d requests", len(requests))
+	}
+}
+
+func TestProxyTarget(t *testing.T) {
+	direct := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"openapi": "3.0.0"}`))
+	}))
+	defer direct.Close()
+
+	service := &v1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoices", Namespace: "billing"},
+		Spec:       v1.ServiceSpec{Ports: []v1.ServicePort{{Name: "http", Port: 80}}},
+	}
+	var requests []k8stesting.ProxyGetAction
+	kube := &Kubernetes{
+		Clientset: newProxyClientset(&requests, service),
+		Dynamic: newDynamicClient(
+			newAPI("invoices", 1, map[string]interface{}{"source": "/v2/openapi.json", "service": "invoices"}),
+			newAPI("external", 1, map[string]interface{}{"source": direct.URL}),
+		),
+		FetchMode: FetchProxy,
+	}
+	ctx := context.Background()
+
+	// An ApiScoutAPI with a service is fetched through the service from the source of the resource
+	endpoint := Endpoint{Name: "invoices", Kind: apiKind, Namespace: "billing", Object: "invoices"}
+	target, path, err := kube.proxyTarget(ctx, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target == nil || target.Name != "invoices" || path != "/v2/openapi.json" {
+		t.Errorf("Unexpected target %v and path %s", target, path)
+	}
+
+	// An ApiScoutAPI with an absolute URL has no service and is fetched directly
+	endpoint = Endpoint{Name: "external", Kind: apiKind, Namespace: "billing", Object: "external", SpecURL: direct.URL}
+	if target, _, err := kube.proxyTarget(ctx, endpoint); err != nil || target != nil {
+		t.Errorf("Expected no service, got %v and %v", target, err)
+	}
+	apidoc, _, err := kube.Fetch(ctx, endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apidoc != `{"openapi": "3.0.0"}` || len(requests) != 0 {
+		t.Errorf("Expected the document to be fetched directly, got %s after %d proxy requests", apidoc, len(requests))
+	}
+
+	// Services that don't exist can't be fetched
+	endpoint = Endpoint{Name: "unknown", Kind: "Service", Namespace: "billing", Object: "unknown"}
+	if _, _, err := kube.proxyTarget(ctx, endpoint); err == nil {
+		t.Error("Expected an error for an unknown service")
+	}
+}
@@ -0,0 +1,447 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// The kind of the Gateway API HTTPRoute resource
+const httpRouteKind = "HTTPRoute"
+
+var (
+	// The Gateway API HTTPRoute resource
+	httpRouteResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "httproutes"}
+	// The Gateway API Gateway resource
+	gatewayResource = schema.GroupVersionResource{Group: "gateway.networking.k8s.io", Version: "v1", Resource: "gateways"}
+)
+
+// handleIngress translates the event for a Kubernetes ingress carrying the apiscout annotations into an API
+// endpoint event. The OpenAPI document is fetched through the host and path of the ingress.
+func (k *Kubernetes) handleIngress(eventType watch.EventType, ingress *networkingv1.Ingress, events chan<- Event) {
+	log.Printf("Received %s for ingress %s in %s\n", eventType, ingress.Name, k.Name())
+
+	endpoint, ok := k.ingressEndpoint(ingress)
+	key := "Ingress/" + ingress.Namespace + "/" + ingress.Name
+
+	switch eventType {
+	case watch.Added, watch.Modified:
+		if ingress.Annotations[annotation] != "true" {
+			// An ingress that no longer has the annotation should be removed from the catalog
+			if eventType == watch.Modified {
+				k.forget(key)
+				events <- Event{Type: Deleted, Endpoint: endpoint}
+			}
+			return
+		}
+		// Changes that don't affect the API (like the status annotation) don't need a new fetch
+		if !k.changed(key, ingress.Annotations, []interface{}{ingress.Spec, endpoint.SpecURL}) && eventType == watch.Modified {
+			return
+		}
+		if !ok {
+			log.Printf("Ingress %s has no host or address to read the OpenAPI document from, so API Scout will ignore\n", ingress.Name)
+			return
+		}
+		if eventType == watch.Added {
+			events <- Event{Type: Added, Endpoint: endpoint}
+		} else {
+			events <- Event{Type: Modified, Endpoint: endpoint}
+		}
+	case watch.Deleted:
+		k.forget(key)
+		if ingress.Annotations[annotation] == "true" {
+			events <- Event{Type: Deleted, Endpoint: endpoint}
+		}
+	default:
+		log.Printf("Received unknown watch.EventType %s, so API Scout will ignore\n", eventType)
+	}
+}
+
+// route is the last seen state of an Ingress or HTTPRoute
+type route struct {
+	// The fingerprint of the parts of the route that determine the public URL of the services
+	fingerprint string
+	// The names of the services the route routes to
+	services []string
+}
+
+// handleRoute indexes the services that an Ingress or HTTPRoute routes to again when the route is added, changed or
+// deleted, as the public URL of a service is looked up through the routes to it. The services that the route no
+// longer routes to are indexed again as well. The spec is what determines the public URL of the services.
+func (k *Kubernetes) handleRoute(ctx context.Context, eventType watch.EventType, kind string, namespace string, name string, services []string, spec interface{}, events chan<- Event) {
+	key := kind + "/" + namespace + "/" + name
+	b, _ := json.Marshal(spec)
+
+	k.mu.Lock()
+	if k.routes == nil {
+		k.routes = make(map[string]route)
+	}
+	previous, seen := k.routes[key]
+	switch eventType {
+	case watch.Added, watch.Modified:
+		k.routes[key] = route{fingerprint: string(b), services: services}
+	case watch.Deleted:
+		delete(k.routes, key)
+	}
+	k.mu.Unlock()
+
+	// A route that is listed again when the watch is restarted, or whose status changed, doesn't change the services
+	if eventType != watch.Deleted && seen && previous.fingerprint == string(b) {
+		return
+	}
+
+	names := make(map[string]bool)
+	for _, service := range append(previous.services, services...) {
+		names[service] = true
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	log.Printf("%s %s in %s routes to %v, so API Scout will index them again\n", kind, name, k.Name(), sortedKeys(names))
+	endpoints, err := k.routeEndpoints(ctx, namespace, names)
+	if err != nil {
+		log.Printf("Error while looking up services of %s %s: %s", kind, name, err.Error())
+		return
+	}
+	for _, endpoint := range endpoints {
+		events <- Event{Type: Modified, Endpoint: endpoint}
+	}
+}
+
+// routeEndpoints returns the API endpoints in the namespace that are served by the services with the names. Those are
+// the services carrying the apiscout annotation and the ApiScoutAPI resources that are backed by the services.
+func (k *Kubernetes) routeEndpoints(ctx context.Context, namespace string, names map[string]bool) ([]Endpoint, error) {
+	var endpoints []Endpoint
+	for _, name := range sortedKeys(names) {
+		service, err := k.Clientset.CoreV1().Services(namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if service.Annotations[annotation] == "true" {
+			endpoints = append(endpoints, k.publicEndpoint(ctx, service))
+		}
+	}
+
+	apis, err := k.serviceAPIs(ctx, namespace, names)
+	if err != nil {
+		return nil, err
+	}
+	return append(endpoints, apis...), nil
+}
+
+// ingressServices returns the names of the services that an Ingress routes to
+func ingressServices(ingress *networkingv1.Ingress) []string {
+	var services []string
+	if backend := ingress.Spec.DefaultBackend; backend != nil && backend.Service != nil {
+		services = append(services, backend.Service.Name)
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.HTTP == nil {
+			continue
+		}
+		for _, path := range rule.HTTP.Paths {
+			if path.Backend.Service != nil {
+				services = append(services, path.Backend.Service.Name)
+			}
+		}
+	}
+	return services
+}
+
+// httpRouteServices returns the names of the services in the namespace of an HTTPRoute that it routes to
+func httpRouteServices(httpRoute *unstructured.Unstructured) []string {
+	var services []string
+	rules, _, _ := unstructured.NestedSlice(httpRoute.Object, "spec", "rules")
+	for _, r := range rules {
+		rule, ok := r.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		backends, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+		for _, b := range backends {
+			backend, ok := b.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name, _, _ := unstructured.NestedString(backend, "name")
+			kind, _, _ := unstructured.NestedString(backend, "kind")
+			namespace, _, _ := unstructured.NestedString(backend, "namespace")
+			if len(name) > 0 && (len(kind) == 0 || kind == "Service") && (len(namespace) == 0 || namespace == httpRoute.GetNamespace()) {
+				services = append(services, name)
+			}
+		}
+	}
+	return services
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ingressEndpoint translates an ingress into an API endpoint using the first path of the first rule of the ingress.
+// When the ingress has no host or address, the endpoint can't be fetched and false is returned.
+func (k *Kubernetes) ingressEndpoint(ingress *networkingv1.Ingress) (Endpoint, bool) {
+	metadata := make(map[string]string)
+	for key, value := range ingress.Annotations {
+		metadata[key] = value
+	}
+
+	endpoint := Endpoint{
+		Name:      "ingress-" + ingress.Name,
+		Source:    k.Name(),
+		Cluster:   k.Cluster,
+		Namespace: ingress.Namespace,
+		Kind:      "Ingress",
+		Object:    ingress.Name,
+		Metadata:  metadata,
+		Labels:    copyLabels(ingress.Labels),
+	}
+
+	for _, rule := range ingress.Spec.Rules {
+		host := rule.Host
+		if len(host) == 0 {
+			host = ingressAddress(*ingress)
+		}
+		if len(host) == 0 {
+			continue
+		}
+
+		prefix := ""
+		if rule.HTTP != nil && len(rule.HTTP.Paths) > 0 {
+			prefix = pathPrefix(rule.HTTP.Paths[0].Path)
+		}
+
+		scheme := "http"
+		if ingressTLS(*ingress, rule.Host) {
+			scheme = "https"
+		}
+
+		endpoint.PublicURL = fmt.Sprintf("%s://%s%s", scheme, host, prefix)
+		endpoint.SpecURL = endpoint.PublicURL + ingress.Annotations[swaggerURL]
+		endpoint.Host = host
+		return endpoint, true
+	}
+
+	return endpoint, false
+}
+
+// publicURL looks for an Ingress or Gateway API HTTPRoute that routes to the service and returns the public URL
+// (scheme, host and path prefix) through which the service is reachable. When the service isn't exposed, an empty
+// string is returned.
+func (k *Kubernetes) publicURL(ctx context.Context, service *v1.Service) string {
+	if url, err := k.ingressURL(ctx, service); err != nil {
+		log.Printf("Error while looking up Ingress for %s: %s", service.Name, err.Error())
+	} else if len(url) > 0 {
+		return url
+	}
+
+	if url, err := k.httpRouteURL(ctx, service); err != nil {
+		log.Printf("Error while looking up HTTPRoute for %s: %s", service.Name, err.Error())
+	} else if len(url) > 0 {
+		return url
+	}
+
+	return ""
+}
+
+// ingressURL returns the public URL of the first Ingress rule in the namespace of the service that routes to it
+func (k *Kubernetes) ingressURL(ctx context.Context, service *v1.Service) (string, error) {
+	ingresses, err := k.Clientset.NetworkingV1().Ingresses(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+
+	for _, ingress := range ingresses.Items {
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.Service == nil || path.Backend.Service.Name != service.Name {
+					continue
+				}
+
+				// Rules without a host are reachable on the address of the load balancer
+				host := rule.Host
+				if len(host) == 0 {
+					host = ingressAddress(ingress)
+				}
+				if len(host) == 0 {
+					continue
+				}
+
+				scheme := "http"
+				if ingressTLS(ingress, rule.Host) {
+					scheme = "https"
+				}
+
+				return fmt.Sprintf("%s://%s%s", scheme, host, pathPrefix(path.Path)), nil
+			}
+		}
+	}
+
+	return "", nil
+}
+
+// ingressAddress returns the address of the load balancer of the Ingress
+func ingressAddress(ingress networkingv1.Ingress) string {
+	for _, lb := range ingress.Status.LoadBalancer.Ingress {
+		if len(lb.Hostname) > 0 {
+			return lb.Hostname
+		}
+		if len(lb.IP) > 0 {
+			return lb.IP
+		}
+	}
+	return ""
+}
+
+// ingressTLS checks whether the Ingress terminates TLS for the host
+func ingressTLS(ingress networkingv1.Ingress, host string) bool {
+	for _, tls := range ingress.Spec.TLS {
+		// Without hosts the TLS configuration applies to all hosts
+		if len(tls.Hosts) == 0 {
+			return true
+		}
+		if contains(tls.Hosts, host) {
+			return true
+		}
+	}
+	return false
+}
+
+// httpRouteURL returns the public URL of the first Gateway API HTTPRoute in the namespace of the service that
+// routes to it. When the Gateway API isn't installed in the cluster, an empty string is returned.
+func (k *Kubernetes) httpRouteURL(ctx context.Context, service *v1.Service) (string, error) {
+	if k.Dynamic == nil {
+		return "", nil
+	}
+
+	routes, err := k.Dynamic.Resource(httpRouteResource).Namespace(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+
+	for _, route := range routes.Items {
+		rules, _, _ := unstructured.NestedSlice(route.Object, "spec", "rules")
+		for _, r := range rules {
+			rule, ok := r.(map[string]interface{})
+			if !ok || !routesToService(rule, service) {
+				continue
+			}
+
+			// Use the path of the first match as the prefix
+			prefix := ""
+			if matches, _, _ := unstructured.NestedSlice(rule, "matches"); len(matches) > 0 {
+				if match, ok := matches[0].(map[string]interface{}); ok {
+					value, _, _ := unstructured.NestedString(match, "path", "value")
+					prefix = pathPrefix(value)
+				}
+			}
+
+			scheme, address := k.gateway(ctx, route)
+			host := address
+			if hostnames, _, _ := unstructured.NestedStringSlice(route.Object, "spec", "hostnames"); len(hostnames) > 0 && !strings.HasPrefix(hostnames[0], "*") {
+				host = hostnames[0]
+			}
+			if len(host) == 0 {
+				continue
+			}
+
+			return fmt.Sprintf("%s://%s%s", scheme, host, prefix), nil
+		}
+	}
+
+	return "", nil
+}
+
+// routesToService checks whether any of the backends of the HTTPRoute rule is the service
+func routesToService(rule map[string]interface{}, service *v1.Service) bool {
+	backends, _, _ := unstructured.NestedSlice(rule, "backendRefs")
+	for _, b := range backends {
+		backend, ok := b.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		name, _, _ := unstructured.NestedString(backend, "name")
+		kind, _, _ := unstructured.NestedString(backend, "kind")
+		namespace, _, _ := unstructured.NestedString(backend, "namespace")
+		if name == service.Name && (len(kind) == 0 || kind == "Service") && (len(namespace) == 0 || namespace == service.Namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// gateway looks up the first parent Gateway of the HTTPRoute and returns the scheme of its listeners and its address
+func (k *Kubernetes) gateway(ctx context.Context, route unstructured.Unstructured) (string, string) {
+	parents, _, _ := unstructured.NestedSlice(route.Object, "spec", "parentRefs")
+	if len(parents) == 0 {
+		return "http", ""
+	}
+
+	parent, ok := parents[0].(map[string]interface{})
+	if !ok {
+		return "http", ""
+	}
+	name, _, _ := unstructured.NestedString(parent, "name")
+	namespace, _, _ := unstructured.NestedString(parent, "namespace")
+	if len(namespace) == 0 {
+		namespace = route.GetNamespace()
+	}
+
+	gateway, err := k.Dynamic.Resource(gatewayResource).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		log.Printf("Error while looking up Gateway %s/%s: %s", namespace, name, err.Error())
+		return "http", ""
+	}
+
+	scheme := "http"
+	listeners, _, _ := unstructured.NestedSlice(gateway.Object, "spec", "listeners")
+	for _, l := range listeners {
+		if listener, ok := l.(map[string]interface{}); ok && listener["protocol"] == "HTTPS" {
+			scheme = "https"
+		}
+	}
+
+	address := ""
+	addresses, _, _ := unstructured.NestedSlice(gateway.Object, "status", "addresses")
+	if len(addresses) > 0 {
+		if a, ok := addresses[0].(map[string]interface{}); ok {
+			address, _, _ = unstructured.NestedString(a, "value")
+		}
+	}
+
+	return scheme, address
+}
+
+// pathPrefix turns the path of an Ingress or HTTPRoute into a path prefix without a trailing slash
+func pathPrefix(path string) string {
+	path = strings.TrimSuffix(path, "*")
+	return strings.TrimSuffix(path, "/")
+}
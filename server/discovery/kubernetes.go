@@ -0,0 +1,459 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TIBCOSoftware/apiscout/server/util"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/tools/record"
+)
+
+const (
+	// The annotation for apiscout to index a service
+	annotation = "apiscout/index"
+	// The annotation for apiscout to get the OpenAPI doc from
+	swaggerURL = "apiscout/swaggerUrl"
+	// The context name to use the in-cluster config for a cluster
+	inCluster = "in-cluster"
+	// The time to wait before watching again after an error
+	kubernetesBackoff = 10 * time.Second
+)
+
+// Kubernetes is a source that watches Kubernetes services for the apiscout annotations
+type Kubernetes struct {
+	// The clientset to connect to the Kubernetes cluster
+	Clientset kubernetes.Interface
+	// The dynamic client to connect to the Kubernetes cluster, used for resources outside of the clientset
+	Dynamic dynamic.Interface
+	// The external IP address of the Kubernetes cluster in case of LOCAL mode
+	ExternalIP string
+	// The label of the cluster, empty when apiscout only watches a single cluster
+	Cluster string
+	// Whether to record the status of indexing in an annotation on the service
+	AnnotateStatus bool
+	// How to fetch the OpenAPI documents of services (can be either DIRECT, PROXY or ENDPOINTS)
+	FetchMode string
+	// Which pods to fetch the OpenAPI documents from when the FetchMode is ENDPOINTS (can be either ALL or FIRST)
+	EndpointSelection string
+	// The recorder for Kubernetes Events about the status of indexing
+	recorder record.EventRecorder
+	// The current health of the watcher
+	health Health
+	// The generations of the ApiScoutAPI resources that have been indexed
+	generations map[string]int64
+	// The fingerprints of the services and ingresses that have been indexed
+	fingerprints map[string]string
+	// The pod templates of the last completed rollouts of Deployments and StatefulSets
+	rollouts map[string]string
+	// The Ingresses and HTTPRoutes that route to services
+	routes map[string]route
+	// The mutex to guard the health, generations, fingerprints, rollouts and routes
+	mu sync.Mutex
+}
+
+// NewKubernetes creates a new Kubernetes source. The runMode determines whether the in-cluster config
+// (KUBE) or the current context in kubeconfig (LOCAL) is used to connect to the cluster
+func NewKubernetes(runMode string, externalIP string) (*Kubernetes, error) {
+	var config *rest.Config
+	var err error
+
+	if strings.ToUpper(runMode) == "KUBE" {
+		// Create the Kubernetes in-cluster config
+		config, err = rest.InClusterConfig()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// use the current context in kubeconfig
+		config, err = clientcmd.BuildConfigFromFlags("", filepath.Join(util.HomeDir(), ".kube", "config"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newKubernetes(config, "", externalIP)
+}
+
+// NewKubernetesCluster creates a new Kubernetes source for the cluster with the label. The kubeconfig is the path to
+// a kubeconfig file (the default loading rules are used when empty) and context is the context in that file to use
+// (the current context is used when empty). The context "in-cluster" uses the in-cluster config.
+func NewKubernetesCluster(cluster string, kubeconfig string, context string) (*Kubernetes, error) {
+	var config *rest.Config
+	var err error
+
+	if context == inCluster {
+		// Create the Kubernetes in-cluster config
+		config, err = rest.InClusterConfig()
+	} else {
+		// Use the context from the kubeconfig
+		rules := clientcmd.NewDefaultClientConfigLoadingRules()
+		if len(kubeconfig) > 0 {
+			rules.ExplicitPath = kubeconfig
+		}
+		overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error while loading config for cluster %s: %s", cluster, err.Error())
+	}
+
+	return newKubernetes(config, cluster, "")
+}
+
+// newKubernetes creates the clients for the config and returns a new Kubernetes source
+func newKubernetes(config *rest.Config, cluster string, externalIP string) (*Kubernetes, error) {
+	// Create the clientset
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the dynamic client for resources that aren't part of the clientset, like the Gateway API
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create the recorder for Kubernetes Events
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: clientset.CoreV1().Events("")})
+	recorder := broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "apiscout"})
+
+	return &Kubernetes{
+		Clientset:    clientset,
+		Dynamic:      dynamicClient,
+		ExternalIP:   externalIP,
+		Cluster:      cluster,
+		recorder:     recorder,
+		health:       Health{Message: "Starting", Since: time.Now()},
+		generations:  make(map[string]int64),
+		fingerprints: make(map[string]string),
+	}, nil
+}
+
+// ParseClusters parses a comma separated list of clusters in the form label=context[@kubeconfig] and creates a
+// Kubernetes source for each of them
+func ParseClusters(clusters string) ([]*Kubernetes, error) {
+	var sources []*Kubernetes
+
+	for _, entry := range strings.Split(clusters, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, fmt.Errorf("invalid cluster %s, expected label=context[@kubeconfig]", entry)
+		}
+		// The pages of a cluster are written in a section named after its label, which can't be the one of the listings
+		if parts[0] == util.ListingsDir {
+			return nil, fmt.Errorf("invalid cluster %s, the label %s is reserved", entry, util.ListingsDir)
+		}
+
+		context, kubeconfig := parts[1], ""
+		if i := strings.Index(context, "@"); i >= 0 {
+			context, kubeconfig = context[:i], context[i+1:]
+		}
+
+		source, err := NewKubernetesCluster(parts[0], kubeconfig, context)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+
+	return sources, nil
+}
+
+// Name returns the name of the source
+func (k *Kubernetes) Name() string {
+	if len(k.Cluster) > 0 {
+		return "kubernetes/" + k.Cluster
+	}
+	return "kubernetes"
+}
+
+// Health returns the current health of the watcher
+func (k *Kubernetes) Health() Health {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.health
+}
+
+// setHealth updates the health of the watcher, keeping the time since when it had the health
+func (k *Kubernetes) setHealth(healthy bool, message string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.health.Healthy != healthy || k.health.Message != message {
+		k.health = Health{Healthy: healthy, Message: message, Since: time.Now()}
+	}
+}
+
+// Watch registers watchers for services, ingresses, HTTPRoutes, ApiScoutAPI resources, Deployments and StatefulSets
+// with the Kubernetes API server and translates the events into API endpoint events. When the API server closes a
+// watch or can't be reached, a new watcher is registered.
+func (k *Kubernetes) Watch(ctx context.Context, events chan<- Event) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	// Watch the other resources in separate go routines, without affecting the health of the cluster
+	wg.Add(5)
+	go func() {
+		defer wg.Done()
+		k.watch(ctx, "ingresses", false, func() (watch.Interface, error) {
+			return k.Clientset.NetworkingV1().Ingresses("").Watch(ctx, metav1.ListOptions{})
+		}, events)
+	}()
+	go func() {
+		defer wg.Done()
+		k.watch(ctx, "httproutes", false, func() (watch.Interface, error) {
+			return k.Dynamic.Resource(httpRouteResource).Namespace("").Watch(ctx, metav1.ListOptions{})
+		}, events)
+	}()
+	go func() {
+		defer wg.Done()
+		k.watch(ctx, "apiscoutapis", false, func() (watch.Interface, error) {
+			return k.Dynamic.Resource(apiResource).Namespace("").Watch(ctx, metav1.ListOptions{})
+		}, events)
+	}()
+	go func() {
+		defer wg.Done()
+		k.watch(ctx, "deployments", false, func() (watch.Interface, error) {
+			return k.Clientset.AppsV1().Deployments("").Watch(ctx, metav1.ListOptions{})
+		}, events)
+	}()
+	go func() {
+		defer wg.Done()
+		k.watch(ctx, "statefulsets", false, func() (watch.Interface, error) {
+			return k.Clientset.AppsV1().StatefulSets("").Watch(ctx, metav1.ListOptions{})
+		}, events)
+	}()
+
+	return k.watch(ctx, "services", true, func() (watch.Interface, error) {
+		return k.Clientset.CoreV1().Services("").Watch(ctx, metav1.ListOptions{})
+	}, events)
+}
+
+// watch keeps a watcher created by newWatcher running for the resource and handles its events until the context is
+// cancelled. When reportHealth is true, the health of the watcher is reported as the health of the cluster.
+func (k *Kubernetes) watch(ctx context.Context, resource string, reportHealth bool, newWatcher func() (watch.Interface, error), events chan<- Event) error {
+	for {
+		// Create a watcher
+		watcher, err := newWatcher()
+		if errors.IsNotFound(err) {
+			log.Printf("Resource %s doesn't exist in %s, so API Scout will not watch it", resource, k.Name())
+			return nil
+		}
+		if err != nil {
+			log.Printf("Error while watching %s in %s: %s", resource, k.Name(), err.Error())
+			if reportHealth {
+				k.setHealth(false, err.Error())
+			}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(kubernetesBackoff):
+			}
+			continue
+		}
+		if reportHealth {
+			k.setHealth(true, "Watching "+resource)
+		}
+
+		// Handle the events that come in from the watcher until it's closed
+		for evt := range watcher.ResultChan() {
+			if evt.Type == watch.Error {
+				err := errors.FromObject(evt.Object)
+				log.Printf("Received an error from the watch for %s in %s: %s", resource, k.Name(), err.Error())
+				if reportHealth {
+					k.setHealth(false, err.Error())
+				}
+				continue
+			}
+			k.handleEvent(ctx, evt, events)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			log.Printf("Watch for %s in %s closed, registering a new watcher", resource, k.Name())
+		}
+	}
+}
+
+// handleEvent takes care of the Kubernetes event and forwards it to the handler for the kind of object
+func (k *Kubernetes) handleEvent(ctx context.Context, evt watch.Event, events chan<- Event) {
+	switch object := evt.Object.(type) {
+	case *v1.Service:
+		k.handleService(ctx, evt.Type, object, events)
+	case *networkingv1.Ingress:
+		k.handleIngress(evt.Type, object, events)
+		k.handleRoute(ctx, evt.Type, "Ingress", object.Namespace, object.Name, ingressServices(object),
+			[]interface{}{object.Spec, object.Status.LoadBalancer}, events)
+	case *appsv1.Deployment:
+		k.handleDeployment(ctx, evt.Type, object, events)
+	case *appsv1.StatefulSet:
+		k.handleStatefulSet(ctx, evt.Type, object, events)
+	case *unstructured.Unstructured:
+		switch object.GetKind() {
+		case apiKind:
+			k.handleAPI(ctx, evt.Type, object, events)
+		case httpRouteKind:
+			spec, _, _ := unstructured.NestedFieldNoCopy(object.Object, "spec")
+			k.handleRoute(ctx, evt.Type, httpRouteKind, object.GetNamespace(), object.GetName(), httpRouteServices(object), spec, events)
+		}
+	}
+}
+
+// handleService translates the event for a Kubernetes service into an API endpoint event
+func (k *Kubernetes) handleService(ctx context.Context, eventType watch.EventType, service *v1.Service, events chan<- Event) {
+	log.Printf("Received %s for service %s in %s\n", eventType, service.Name, k.Name())
+
+	key := "Service/" + service.Namespace + "/" + service.Name
+
+	switch eventType {
+	case watch.Added:
+		if service.Annotations[annotation] == "true" {
+			k.changed(key, service.Annotations, service.Spec.Ports)
+			events <- Event{Type: Added, Endpoint: k.publicEndpoint(ctx, service)}
+		}
+	case watch.Modified:
+		// A service that no longer has the annotation should be removed from the catalog
+		if service.Annotations[annotation] == "true" {
+			// Changes that don't affect the API (like the status annotation) don't need a new fetch
+			if !k.changed(key, service.Annotations, service.Spec.Ports) {
+				return
+			}
+			events <- Event{Type: Modified, Endpoint: k.publicEndpoint(ctx, service)}
+		} else {
+			k.forget(key)
+			events <- Event{Type: Deleted, Endpoint: k.endpoint(service)}
+		}
+	case watch.Deleted:
+		k.forget(key)
+		events <- Event{Type: Deleted, Endpoint: k.endpoint(service)}
+	default:
+		log.Printf("Received unknown watch.EventType %s, so API Scout will ignore\n", eventType)
+	}
+}
+
+// changed records the fingerprint of the annotations (except the status annotation) and spec of an object and
+// returns whether it differs from the fingerprint that was recorded before
+func (k *Kubernetes) changed(key string, annotations map[string]string, spec interface{}) bool {
+	filtered := make(map[string]string)
+	for name, value := range annotations {
+		if name != statusAnnotation {
+			filtered[name] = value
+		}
+	}
+	b, _ := json.Marshal(map[string]interface{}{"annotations": filtered, "spec": spec})
+	fingerprint := string(b)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.fingerprints == nil {
+		k.fingerprints = make(map[string]string)
+	}
+	previous, ok := k.fingerprints[key]
+	k.fingerprints[key] = fingerprint
+	return !ok || previous != fingerprint
+}
+
+// forget removes the fingerprint of an object that is no longer indexed
+func (k *Kubernetes) forget(key string) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	delete(k.fingerprints, key)
+}
+
+// publicEndpoint translates a Kubernetes service into an API endpoint, including the public URL through which
+// the service is exposed
+func (k *Kubernetes) publicEndpoint(ctx context.Context, service *v1.Service) Endpoint {
+	endpoint := k.endpoint(service)
+	endpoint.Workload, endpoint.Image = k.serviceWorkload(ctx, service)
+
+	publicURL := k.publicURL(ctx, service)
+	if u, err := url.Parse(publicURL); err == nil && len(publicURL) > 0 {
+		log.Printf("Service %s is exposed as %s\n", service.Name, publicURL)
+		endpoint.PublicURL = publicURL
+		endpoint.Host = u.Host
+	}
+
+	return endpoint
+}
+
+// endpoint translates a Kubernetes service into an API endpoint
+func (k *Kubernetes) endpoint(service *v1.Service) Endpoint {
+	metadata := make(map[string]string)
+	for key, value := range service.Annotations {
+		metadata[key] = value
+	}
+
+	address := k.address(service)
+
+	return Endpoint{
+		Name:      service.Name,
+		Source:    k.Name(),
+		Cluster:   k.Cluster,
+		Namespace: service.Namespace,
+		Kind:      "Service",
+		Object:    service.Name,
+		SpecURL:   fmt.Sprintf("http://%s%s", address, service.Annotations[swaggerURL]),
+		Host:      address,
+		Metadata:  metadata,
+		Labels:    copyLabels(service.Labels),
+	}
+}
+
+// copyLabels returns a copy of the labels of an object, so the endpoint doesn't share them with the object
+func copyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
+
+// address returns the IP address and port on which apiscout can reach the service
+func (k *Kubernetes) address(service *v1.Service) string {
+	var ip string
+	var port int32
+
+	if len(service.Spec.Ports) > 0 {
+		if len(k.ExternalIP) > 0 {
+			ip = k.ExternalIP
+			port = service.Spec.Ports[0].NodePort
+		} else {
+			ip = service.Spec.ClusterIP
+			port = service.Spec.Ports[0].Port
+		}
+	}
+
+	return fmt.Sprintf("%s:%d", ip, port)
+}
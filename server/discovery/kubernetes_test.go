@@ -0,0 +1,434 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const kubeServicePayload = `{
+    "metadata": {
+        "name": "invoice-go-svc",
+        "namespace": "default",
+        "selfLink": "/api/v1/namespaces/default/services/invoice-go-svc",
+        "uid": "a3f33d97-e0cb-11e8-8617-c85b76f2707f",
+        "resourceVersion": "2982",
+        "creationTimestamp": "2018-11-05T07:23:06Z",
+        "labels": {
+            "run": "invoice-go-svc"
+        },
+        "annotations": {
+            "apiscout/index": "true",
+            "apiscout/swaggerUrl": "/swaggerspec",
+            "kubectl.kubernetes.io/last-applied-configuration": "{\"apiVersion\":\"v1\",\"kind\":\"Service\",\"metadata\":{\"annotations\":{\"apiscout/index\":\"true\",\"apiscout/swaggerUrl\":\"/swaggerspec\"},\"labels\":{\"run\":\"invoice-go-svc\"},\"name\":\"invoice-go-svc\",\"namespace\":\"default\"},\"spec\":{\"ports\":[{\"port\":80,\"protocol\":\"TCP\",\"targetPort\":8080}],\"selector\":{\"run\":\"invoice-go-svc\"},\"type\":\"LoadBalancer\"}}\n"
+        }
+    },
+    "spec": {
+        "ports": [
+            {
+                "protocol": "TCP",
+                "port": 80,
+                "targetPort": 8123,
+                "nodePort": 8123
+            }
+        ],
+        "selector": {
+            "run": "invoice-go-svc"
+        },
+        "clusterIP": "10.99.164.156",
+        "type": "LoadBalancer",
+        "sessionAffinity": "None",
+        "externalTrafficPolicy": "Cluster"
+    },
+    "status": {
+        "loadBalancer": {}
+    }
+}`
+
+func TestKubernetesEvents(t *testing.T) {
+	service := &v1.Service{}
+	json.Unmarshal([]byte(kubeServicePayload), service)
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(), ExternalIP: "localhost"}
+	ctx := context.Background()
+	events := make(chan Event, 1)
+
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: service}, events)
+	evt := <-events
+	if evt.Type != Added {
+		t.Fatalf("Expected %s event, got %s", Added, evt.Type)
+	}
+	if evt.Endpoint.SpecURL != "http://localhost:8123/swaggerspec" {
+		t.Fatalf("Unexpected spec URL %s", evt.Endpoint.SpecURL)
+	}
+	if evt.Endpoint.Host != "localhost:8123" {
+		t.Fatalf("Unexpected host %s", evt.Endpoint.Host)
+	}
+
+	// A change to the status annotation only shouldn't cause the service to be indexed again
+	service.Annotations[statusAnnotation] = `{"result": "Indexed"}`
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: service}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+
+	// A service without the annotation should be removed when it is modified
+	delete(service.Annotations, annotation)
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: service}, events)
+	evt = <-events
+	if evt.Type != Deleted {
+		t.Fatalf("Expected %s event, got %s", Deleted, evt.Type)
+	}
+}
+
+func TestKubernetesWatchError(t *testing.T) {
+	kube := &Kubernetes{Cluster: "prod"}
+	watcher := watch.NewFake()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- kube.watch(ctx, "services", true, func() (watch.Interface, error) { return watcher, nil }, make(chan Event))
+	}()
+
+	// An error from the watch of services makes the cluster unhealthy until a new watcher is registered
+	watcher.Error(&metav1.Status{Status: metav1.StatusFailure, Message: "too old resource version"})
+	for i := 0; i < 100 && kube.Health().Healthy; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if health := kube.Health(); health.Healthy || health.Message != "too old resource version" {
+		t.Errorf("Expected the cluster to be unhealthy, got %+v", health)
+	}
+
+	cancel()
+	watcher.Stop()
+	<-done
+}
+
+func TestKubernetesIngress(t *testing.T) {
+	service := &v1.Service{}
+	json.Unmarshal([]byte(kubeServicePayload), service)
+
+	pathType := networkingv1.PathTypePrefix
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoices", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			TLS: []networkingv1.IngressTLS{{Hosts: []string{"api.example.com"}}},
+			Rules: []networkingv1.IngressRule{{
+				Host: "api.example.com",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:     "/invoices/",
+						PathType: &pathType,
+						Backend: networkingv1.IngressBackend{
+							Service: &networkingv1.IngressServiceBackend{Name: "invoice-go-svc"},
+						},
+					}},
+				}},
+			}},
+		},
+	}
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(ingress)}
+	endpoint := kube.publicEndpoint(context.Background(), service)
+	if endpoint.PublicURL != "https://api.example.com/invoices" {
+		t.Fatalf("Unexpected public URL %s", endpoint.PublicURL)
+	}
+	if endpoint.Host != "api.example.com" {
+		t.Fatalf("Unexpected host %s", endpoint.Host)
+	}
+}
+
+func TestKubernetesIngressEvents(t *testing.T) {
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "invoices",
+			Namespace:   "default",
+			Annotations: map[string]string{annotation: "true", swaggerURL: "/swaggerspec"},
+		},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "api.example.com",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{Path: "/invoices"}},
+				}},
+			}},
+		},
+	}
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset()}
+	events := make(chan Event, 1)
+
+	kube.handleEvent(context.Background(), watch.Event{Type: watch.Added, Object: ingress}, events)
+	evt := <-events
+	if evt.Type != Added {
+		t.Fatalf("Expected %s event, got %s", Added, evt.Type)
+	}
+	if evt.Endpoint.SpecURL != "http://api.example.com/invoices/swaggerspec" {
+		t.Fatalf("Unexpected spec URL %s", evt.Endpoint.SpecURL)
+	}
+	if evt.Endpoint.Host != "api.example.com" {
+		t.Fatalf("Unexpected host %s", evt.Endpoint.Host)
+	}
+}
+
+func TestKubernetesRoutes(t *testing.T) {
+	service := &v1.Service{}
+	json.Unmarshal([]byte(kubeServicePayload), service)
+	ingress := &networkingv1.Ingress{
+		ObjectMeta: metav1.ObjectMeta{Name: "gateway", Namespace: "default"},
+		Spec: networkingv1.IngressSpec{
+			Rules: []networkingv1.IngressRule{{
+				Host: "api.example.com",
+				IngressRuleValue: networkingv1.IngressRuleValue{HTTP: &networkingv1.HTTPIngressRuleValue{
+					Paths: []networkingv1.HTTPIngressPath{{
+						Path:    "/invoices",
+						Backend: networkingv1.IngressBackend{Service: &networkingv1.IngressServiceBackend{Name: "invoice-go-svc"}},
+					}},
+				}},
+			}},
+		},
+	}
+	httpRoute := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "gateway.networking.k8s.io/v1",
+		"kind":       httpRouteKind,
+		"metadata":   map[string]interface{}{"name": "invoices", "namespace": "default"},
+		"spec": map[string]interface{}{
+			"hostnames": []interface{}{"gateway.example.com"},
+			"rules":     []interface{}{map[string]interface{}{"backendRefs": []interface{}{map[string]interface{}{"name": "invoice-go-svc"}}}},
+		},
+	}}
+
+	clientset := fake.NewSimpleClientset(service, ingress)
+	kube := &Kubernetes{Clientset: clientset, Dynamic: newDynamicClient()}
+	ctx := context.Background()
+	events := make(chan Event, 2)
+
+	// Only the events for the service matter, as an Ingress without the apiscout annotation is removed when it changes
+	serviceEvents := func() []Event {
+		var received []Event
+		for len(events) > 0 {
+			if evt := <-events; evt.Endpoint.Kind == "Service" {
+				received = append(received, evt)
+			}
+		}
+		return received
+	}
+
+	// The service is indexed again with its public URL when an Ingress that routes to it is added
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: ingress}, events)
+	if received := serviceEvents(); len(received) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(received))
+	} else if evt := received[0]; evt.Type != Modified || evt.Endpoint.Object != "invoice-go-svc" || evt.Endpoint.PublicURL != "http://api.example.com/invoices" {
+		t.Fatalf("Unexpected event %s for %s with public URL %s", evt.Type, evt.Endpoint.Object, evt.Endpoint.PublicURL)
+	}
+
+	// Changes that don't affect the routes don't cause the service to be indexed again
+	ingress.Labels = map[string]string{"team": "invoicing"}
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: ingress}, events)
+	if received := serviceEvents(); len(received) != 0 {
+		t.Fatalf("Expected no events, got %d", len(received))
+	}
+
+	// The service that the Ingress no longer routes to is indexed again without the public URL
+	ingress.Spec.Rules[0].HTTP.Paths[0].Backend.Service.Name = "reports-svc"
+	clientset.NetworkingV1().Ingresses("default").Update(ctx, ingress, metav1.UpdateOptions{})
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: ingress}, events)
+	if received := serviceEvents(); len(received) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(received))
+	} else if evt := received[0]; evt.Endpoint.Object != "invoice-go-svc" || len(evt.Endpoint.PublicURL) > 0 {
+		t.Fatalf("Unexpected event for %s with public URL %s", evt.Endpoint.Object, evt.Endpoint.PublicURL)
+	}
+
+	// The same goes for HTTPRoutes
+	kube.Dynamic = newDynamicClient(httpRoute)
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: httpRoute}, events)
+	if received := serviceEvents(); len(received) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(received))
+	} else if evt := received[0]; evt.Endpoint.Object != "invoice-go-svc" || evt.Endpoint.PublicURL != "http://gateway.example.com" {
+		t.Fatalf("Unexpected event for %s with public URL %s", evt.Endpoint.Object, evt.Endpoint.PublicURL)
+	}
+	kube.handleEvent(ctx, watch.Event{Type: watch.Deleted, Object: httpRoute}, events)
+	if received := serviceEvents(); len(received) != 1 {
+		t.Fatalf("Expected 1 event, got %d", len(received))
+	}
+}
+
+func TestKubernetesRollout(t *testing.T) {
+	service := &v1.Service{}
+	json.Unmarshal([]byte(kubeServicePayload), service)
+	service.Spec.Selector = map[string]string{"run": "invoice-go-svc"}
+
+	replicas := int32(2)
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoice-go", Namespace: "default", Generation: 1},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"run": "invoice-go-svc"}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "invoice", Image: "invoice:1.0.0"}}},
+			},
+		},
+		Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+	}
+
+	api := newAPI("invoices", 1, map[string]interface{}{"source": "/openapi.json", "service": "invoice-go-svc"})
+	api.SetNamespace("default")
+	other := newAPI("reports", 1, map[string]interface{}{"source": "/openapi.json", "service": "reports-svc"})
+	other.SetNamespace("default")
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(service), Dynamic: newDynamicClient(api, other), ExternalIP: "localhost"}
+	ctx := context.Background()
+	events := make(chan Event, 3)
+
+	// A deployment that is already rolled out doesn't cause the service to be indexed again
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: deployment}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+
+	// A rollout in progress doesn't cause the service to be indexed again yet
+	deployment.Generation = 2
+	deployment.Spec.Template.Spec.Containers[0].Image = "invoice:1.1.0"
+	deployment.Status.UpdatedReplicas = 1
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: deployment}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+
+	// The service and the ApiScoutAPI backed by the service are indexed again once the rollout completes
+	deployment.Status.ObservedGeneration = 2
+	deployment.Status.UpdatedReplicas = 2
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: deployment}, events)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	for _, expected := range []string{"Service/invoice-go-svc", apiKind + "/invoices"} {
+		evt := <-events
+		if evt.Type != Modified {
+			t.Fatalf("Expected %s event, got %s", Modified, evt.Type)
+		}
+		if evt.Endpoint.Kind+"/"+evt.Endpoint.Object != expected {
+			t.Fatalf("Expected endpoint %s, got %s/%s", expected, evt.Endpoint.Kind, evt.Endpoint.Object)
+		}
+	}
+
+	// Status updates after the rollout don't cause the service to be indexed again
+	kube.handleEvent(ctx, watch.Event{Type: watch.Modified, Object: deployment}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+
+	// Listing the deployment again when the watch is restarted doesn't cause the service to be indexed again
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: deployment}, events)
+	if len(events) != 0 {
+		t.Fatalf("Expected no events, got %d", len(events))
+	}
+
+	// Unless it rolled out while the watch was down
+	deployment.Generation = 3
+	deployment.Spec.Template.Spec.Containers[0].Image = "invoice:1.2.0"
+	deployment.Status.ObservedGeneration = 3
+	kube.handleEvent(ctx, watch.Event{Type: watch.Added, Object: deployment}, events)
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+}
+
+func TestKubernetesWorkload(t *testing.T) {
+	service := &v1.Service{}
+	json.Unmarshal([]byte(kubeServicePayload), service)
+
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "invoice-go", Namespace: "default"},
+		Spec: appsv1.DeploymentSpec{
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"run": "invoice-go-svc", "version": "1.1.0"}},
+				Spec:       v1.PodSpec{Containers: []v1.Container{{Name: "invoice", Image: "registry:5000/invoice:1.1.0"}}},
+			},
+		},
+	}
+
+	kube := &Kubernetes{Clientset: fake.NewSimpleClientset(deployment), ExternalIP: "localhost"}
+	endpoint := kube.publicEndpoint(context.Background(), service)
+	if endpoint.Workload != "Deployment/invoice-go" {
+		t.Fatalf("Unexpected workload %s", endpoint.Workload)
+	}
+	if endpoint.Image != "registry:5000/invoice:1.1.0" {
+		t.Fatalf("Unexpected image %s", endpoint.Image)
+	}
+	if endpoint.Labels["run"] != "invoice-go-svc" {
+		t.Fatalf("Unexpected labels %v", endpoint.Labels)
+	}
+}
+
+const kubeconfigPayload = `apiVersion: v1
+kind: Config
+clusters:
+- name: prod
+  cluster:
+    server: https://prod.example.com
+- name: staging
+  cluster:
+    server: https://staging.example.com
+users:
+- name: apiscout
+  user:
+    token: secret
+contexts:
+- name: prod-context
+  context:
+    cluster: prod
+    user: apiscout
+- name: staging-context
+  context:
+    cluster: staging
+    user: apiscout
+current-context: prod-context
+`
+
+func TestParseClusters(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apiscout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	kubeconfig := filepath.Join(dir, "kubeconfig")
+	if err := ioutil.WriteFile(kubeconfig, []byte(kubeconfigPayload), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sources, err := ParseClusters(" prod=prod-context@" + kubeconfig + ",, staging=staging-context@" + kubeconfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sources) != 2 || sources[0].Cluster != "prod" || sources[1].Cluster != "staging" {
+		t.Fatalf("Expected the prod and staging clusters, got %+v", sources)
+	}
+	if sources[0].Name() != "kubernetes/prod" {
+		t.Errorf("Expected kubernetes/prod, got %s", sources[0].Name())
+	}
+
+	for clusters, expected := range map[string]string{
+		"prod":                               "invalid cluster prod",
+		"=prod-context":                      "invalid cluster =prod-context",
+		"catalog=prod-context":               "the label catalog is reserved",
+		"prod=unknown-context@" + kubeconfig: "error while loading config for cluster prod",
+	} {
+		if _, err := ParseClusters(clusters); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error with %q for %s, got %v", expected, clusters, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"log"
+	"strings"
+
+	"github.com/TIBCOSoftware/apiscout/server/util"
+)
+
+// The annotations with who to contact about an API
+const (
+	// The person or team that owns the API
+	ownerAnnotation = "apiscout/owner"
+	// The team that owns the API
+	teamAnnotation = "apiscout/team"
+	// The Slack channel to ask questions about the API in
+	slackAnnotation = "apiscout/slack"
+	// The URL of the repository with the source of the API
+	repoAnnotation = "apiscout/repo"
+	// The lifecycle stage of the API (can be either experimental, stable or deprecated)
+	lifecycleAnnotation = "apiscout/lifecycle"
+	// The date from which the API is deprecated
+	deprecationAnnotation = "apiscout/deprecation"
+	// The date on which the API will be removed
+	sunsetAnnotation = "apiscout/sunset"
+	// The comma separated categories (domains) the API is listed under
+	categoryAnnotation = "apiscout/category"
+	// The comma separated tags of the API
+	tagsAnnotation = "apiscout/tags"
+	// Who the API is for (can be either public or internal)
+	visibilityAnnotation = "apiscout/visibility"
+)
+
+// visibilityInternal is the visibility of APIs that are only published to the restricted audience
+const visibilityInternal = "internal"
+
+// declaredFields are the names of the ownership, lifecycle and category annotations without their prefix, which is how sources
+// that don't have annotations declare them
+var declaredFields = []string{"owner", "team", "slack", "repo", "lifecycle", "deprecation", "sunset", "category"}
+
+// Ownership returns who to contact about the API from the ownership annotations of the API endpoint. A lifecycle
+// other than experimental, stable or deprecated is ignored.
+func (e Endpoint) Ownership() util.Ownership {
+	ownership := util.Ownership{
+		Owner:     e.Metadata[ownerAnnotation],
+		Team:      e.Metadata[teamAnnotation],
+		Slack:     e.Metadata[slackAnnotation],
+		Repo:      e.Metadata[repoAnnotation],
+		Lifecycle: strings.ToLower(e.Metadata[lifecycleAnnotation]),
+	}
+	if len(ownership.Lifecycle) > 0 && !util.ValidLifecycle(ownership.Lifecycle) {
+		log.Printf("Ignoring unknown lifecycle %s of %s\n", ownership.Lifecycle, e.Name)
+		ownership.Lifecycle = ""
+	}
+	return ownership
+}
+
+// Sunset returns the deprecation and sunset dates of the API from the annotations of the API endpoint, as they were
+// declared
+func (e Endpoint) Sunset() (string, string) {
+	return e.Metadata[deprecationAnnotation], e.Metadata[sunsetAnnotation]
+}
+
+// Internal checks whether the API is declared internal, in which case it is only published to the restricted audience
+func (e Endpoint) Internal() bool {
+	return strings.ToLower(e.Metadata[visibilityAnnotation]) == visibilityInternal
+}
+
+// Taxonomies returns the terms by which the API can be browsed, from the tags in its OpenAPI document, the tags and
+// categories annotations and the labels of the API endpoint
+func (e Endpoint) Taxonomies(apidoc string) util.Taxonomies {
+	return util.NewTaxonomies(apidoc, util.SplitTerms(e.Metadata[tagsAnnotation]), util.SplitTerms(e.Metadata[categoryAnnotation]), e.Labels)
+}
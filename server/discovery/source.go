@@ -0,0 +1,154 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// EventType describes what happened to an API endpoint
+type EventType string
+
+const (
+	// Added means a new API endpoint appeared
+	Added EventType = "ADDED"
+	// Modified means an existing API endpoint changed
+	Modified EventType = "MODIFIED"
+	// Deleted means an API endpoint disappeared
+	Deleted EventType = "DELETED"
+)
+
+// Endpoint represents an API that has been discovered by a source, independent of where it was discovered
+type Endpoint struct {
+	// The unique name of the API, used to store the documents
+	Name string
+	// The name of the source that discovered the API
+	Source string
+	// The cluster in which the API was discovered, empty when the source isn't tied to a cluster
+	Cluster string
+	// The namespace in which the API was discovered, empty when the source has no namespaces
+	Namespace string
+	// The kind of object the API was discovered from (like Service), empty when the source has no objects
+	Kind string
+	// The name of the object the API was discovered from
+	Object string
+	// The URL from where to read the OpenAPI document
+	SpecURL string
+	// The host (and port) that should be written into the OpenAPI document
+	Host string
+	// The public URL (scheme, host and path prefix) through which consumers reach the API, when it is known
+	PublicURL string
+	// Additional key/value metadata the source knows about the API
+	Metadata map[string]string
+	// The labels of the object the API was discovered from
+	Labels map[string]string
+	// The workload that runs the API as kind/name (like Deployment/invoices), empty when it isn't known
+	Workload string
+	// The image of the first container of the workload, empty when it isn't known
+	Image string
+}
+
+// Key returns the key that uniquely identifies the API endpoint across all sources, so APIs with the same name that
+// were discovered by different sources, from different kinds of objects or in different namespaces don't replace
+// each other
+func (e Endpoint) Key() string {
+	return strings.Join([]string{e.Source, e.Cluster, e.Kind, e.Namespace, e.Name}, "/")
+}
+
+// PageName returns the name under which the documents and the page of the API endpoint are stored, which is unique
+// within the cluster as the documents of a cluster are stored in a directory of their own. The name is prefixed with
+// the type of the source (without the cluster it watches), the kind of object and the namespace as far as they are
+// known. Only the name of the API can contain an underscore, so the parts can't run into each other.
+func (e Endpoint) PageName() string {
+	var parts []string
+	for _, part := range []string{strings.SplitN(e.Source, "/", 2)[0], e.Kind, e.Namespace, e.Name} {
+		if len(part) > 0 {
+			parts = append(parts, part)
+		}
+	}
+	return strings.ToLower(strings.Join(parts, "_"))
+}
+
+// Event is a normalized notification that an API endpoint appeared, changed or disappeared
+type Event struct {
+	// The type of the event
+	Type EventType
+	// The API endpoint the event is about
+	Endpoint Endpoint
+}
+
+// Source is implemented by everything APIScout can discover APIs from
+type Source interface {
+	// Name returns a short, human readable name of the source
+	Name() string
+	// Watch sends events for API endpoints to the events channel until the context is cancelled or
+	// the source fails
+	Watch(ctx context.Context, events chan<- Event) error
+}
+
+// Health describes whether a source is able to discover APIs
+type Health struct {
+	// Whether the source is currently working
+	Healthy bool
+	// A human readable explanation of the health
+	Message string
+	// The time since when the source has had this health
+	Since time.Time
+}
+
+// HealthReporter is implemented by sources that can report on their health
+type HealthReporter interface {
+	// Health returns the current health of the source
+	Health() Health
+}
+
+// Result describes the outcome of indexing an API endpoint
+type Result string
+
+const (
+	// Indexed means the OpenAPI document was fetched and published
+	Indexed Result = "Indexed"
+	// FetchFailed means the OpenAPI document couldn't be fetched
+	FetchFailed Result = "FetchFailed"
+	// InvalidSpec means the OpenAPI document was fetched, but couldn't be published
+	InvalidSpec Result = "InvalidSpec"
+)
+
+// Status is the outcome of indexing an API endpoint
+type Status struct {
+	// The result of indexing
+	Result Result
+	// A human readable explanation of the result
+	Message string
+	// The time the API endpoint was indexed
+	Time time.Time
+	// The hash of the OpenAPI document that was indexed, as it was fetched (before the public URL was set and the
+	// internal parts were stripped), so it only changes when the service serves another document
+	Hash string
+	// The problems that were found when validating the OpenAPI document
+	Problems []string
+}
+
+// StatusReporter is implemented by sources that write the status of indexing back to where the API was discovered
+type StatusReporter interface {
+	// ReportStatus records the status of indexing an API endpoint that was discovered by the source, giving up when
+	// the context is cancelled (like when the replica is no longer the leader)
+	ReportStatus(ctx context.Context, endpoint Endpoint, status Status)
+}
+
+// Fetcher is implemented by sources that fetch the OpenAPI documents of the APIs they discovered themselves, rather
+// than having them fetched from the SpecURL of the API endpoint
+type Fetcher interface {
+	// Fetch returns the OpenAPI document of an API endpoint that was discovered by the source, together with
+	// warnings about the document that should be shown in the catalog
+	Fetch(ctx context.Context, endpoint Endpoint) (string, []string, error)
+}
+
+// RefLoader is implemented by sources that load the files referenced by the OpenAPI documents of the APIs they
+// discovered themselves, rather than having them loaded from their URL
+type RefLoader interface {
+	// LoadRef returns the file at the absolute URL, which is referenced by the OpenAPI document of an API endpoint
+	// that was discovered by the source, reading at most maxSize bytes
+	LoadRef(ctx context.Context, endpoint Endpoint, url string, maxSize int) (string, error)
+}
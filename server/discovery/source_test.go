@@ -0,0 +1,22 @@
+package discovery
+
+import "testing"
+
+func TestEndpointKey(t *testing.T) {
+	service := Endpoint{Name: "invoices", Source: "kubernetes/prod", Cluster: "prod", Kind: "Service", Namespace: "billing"}
+	api := Endpoint{Name: "invoices", Source: "kubernetes/prod", Cluster: "prod", Kind: apiKind, Namespace: "billing"}
+	consul := Endpoint{Name: "invoices", Source: "consul"}
+
+	if service.Key() == api.Key() || service.Key() == consul.Key() {
+		t.Errorf("Expected different keys, got %s, %s and %s", service.Key(), api.Key(), consul.Key())
+	}
+	if name := service.PageName(); name != "kubernetes_service_billing_invoices" {
+		t.Errorf("Expected kubernetes_service_billing_invoices, got %s", name)
+	}
+	if name := api.PageName(); name != "kubernetes_apiscoutapi_billing_invoices" {
+		t.Errorf("Expected kubernetes_apiscoutapi_billing_invoices, got %s", name)
+	}
+	if name := consul.PageName(); name != "consul_invoices" {
+		t.Errorf("Expected consul_invoices, got %s", name)
+	}
+}
@@ -0,0 +1,97 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// The annotation in which apiscout records the status of indexing
+const statusAnnotation = "apiscout/status"
+
+// annotationStatus is the status of indexing as it is recorded in the status annotation
+type annotationStatus struct {
+	Result      Result   `json:"result"`
+	Message     string   `json:"message,omitempty"`
+	LastIndexed string   `json:"lastIndexed,omitempty"`
+	SpecHash    string   `json:"specHash,omitempty"`
+	Problems    []string `json:"problems,omitempty"`
+}
+
+// ReportStatus writes the status of indexing back to the object the API endpoint was discovered from. ApiScoutAPI
+// resources get conditions, while services and ingresses get Kubernetes Events and optionally a status annotation.
+func (k *Kubernetes) ReportStatus(ctx context.Context, endpoint Endpoint, status Status) {
+	switch endpoint.Kind {
+	case apiKind:
+		k.reportAPIStatus(ctx, endpoint, status)
+	case "Service", "Ingress":
+		k.reportObjectStatus(ctx, endpoint, status)
+	}
+}
+
+// reportObjectStatus records a Kubernetes Event for the status of indexing on the service or ingress and, when
+// AnnotateStatus is enabled, patches the status annotation of the object
+func (k *Kubernetes) reportObjectStatus(ctx context.Context, endpoint Endpoint, status Status) {
+	var object runtime.Object
+	var err error
+	switch endpoint.Kind {
+	case "Service":
+		object, err = k.Clientset.CoreV1().Services(endpoint.Namespace).Get(ctx, endpoint.Object, metav1.GetOptions{})
+	case "Ingress":
+		object, err = k.Clientset.NetworkingV1().Ingresses(endpoint.Namespace).Get(ctx, endpoint.Object, metav1.GetOptions{})
+	}
+	if err != nil {
+		log.Printf("Error while getting %s %s to record status: %s", endpoint.Kind, endpoint.Object, err.Error())
+		return
+	}
+
+	// Record the Kubernetes Event
+	if k.recorder != nil {
+		eventType := v1.EventTypeWarning
+		if status.Result == Indexed {
+			eventType = v1.EventTypeNormal
+		}
+		k.recorder.Event(object, eventType, string(status.Result), status.Message)
+	}
+
+	if !k.AnnotateStatus {
+		return
+	}
+
+	// Patch the status annotation
+	value := annotationStatus{Result: status.Result, Message: status.Message, SpecHash: status.Hash, Problems: status.Problems}
+	if status.Result == Indexed {
+		value.LastIndexed = status.Time.Format(time.RFC3339)
+	}
+	annotation, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("Error while marshaling status of %s: %s", endpoint.Object, err.Error())
+		return
+	}
+	patch, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"annotations": map[string]string{statusAnnotation: string(annotation)},
+		},
+	})
+	if err != nil {
+		log.Printf("Error while marshaling status of %s: %s", endpoint.Object, err.Error())
+		return
+	}
+
+	switch endpoint.Kind {
+	case "Service":
+		_, err = k.Clientset.CoreV1().Services(endpoint.Namespace).Patch(ctx, endpoint.Object, types.MergePatchType, patch, metav1.PatchOptions{})
+	case "Ingress":
+		_, err = k.Clientset.NetworkingV1().Ingresses(endpoint.Namespace).Patch(ctx, endpoint.Object, types.MergePatchType, patch, metav1.PatchOptions{})
+	}
+	if err != nil {
+		log.Printf("Error while patching status annotation of %s %s: %s", endpoint.Kind, endpoint.Object, err.Error())
+	}
+}
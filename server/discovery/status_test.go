@@ -0,0 +1,69 @@
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+)
+
+func TestReportStatus(t *testing.T) {
+	service := &v1.Service{ObjectMeta: metav1.ObjectMeta{Name: "invoices", Namespace: "billing"}}
+	ingress := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "reports", Namespace: "billing"}}
+	clientset := fake.NewSimpleClientset(service, ingress)
+	recorder := record.NewFakeRecorder(10)
+	kube := &Kubernetes{Clientset: clientset, recorder: recorder, AnnotateStatus: true}
+	ctx := context.Background()
+	indexed := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	kube.ReportStatus(ctx, Endpoint{Kind: "Service", Namespace: "billing", Object: "invoices"},
+		Status{Result: Indexed, Message: "The OpenAPI document was indexed", Time: indexed, Hash: "abc", Problems: []string{"info: missing version"}})
+	if event := <-recorder.Events; event != "Normal Indexed The OpenAPI document was indexed" {
+		t.Errorf("Unexpected event %s", event)
+	}
+	service, _ = clientset.CoreV1().Services("billing").Get(ctx, "invoices", metav1.GetOptions{})
+	var status annotationStatus
+	if err := json.Unmarshal([]byte(service.Annotations[statusAnnotation]), &status); err != nil {
+		t.Fatalf("Expected the status annotation, got %v", service.Annotations)
+	}
+	expected := annotationStatus{Result: Indexed, Message: "The OpenAPI document was indexed", LastIndexed: "2026-10-01T12:00:00Z", SpecHash: "abc", Problems: []string{"info: missing version"}}
+	if !reflect.DeepEqual(status, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, status)
+	}
+
+	// Failures are warnings and don't have a time they were last indexed
+	kube.ReportStatus(ctx, Endpoint{Kind: "Ingress", Namespace: "billing", Object: "reports"}, Status{Result: FetchFailed, Message: "connection refused", Time: indexed})
+	if event := <-recorder.Events; event != "Warning FetchFailed connection refused" {
+		t.Errorf("Unexpected event %s", event)
+	}
+	ingress, _ = clientset.NetworkingV1().Ingresses("billing").Get(ctx, "reports", metav1.GetOptions{})
+	if annotation := ingress.Annotations[statusAnnotation]; annotation != `{"result":"FetchFailed","message":"connection refused"}` {
+		t.Errorf("Unexpected status annotation %s", annotation)
+	}
+
+	// Without AnnotateStatus only the event is recorded
+	kube.AnnotateStatus = false
+	clientset.ClearActions()
+	kube.ReportStatus(ctx, Endpoint{Kind: "Service", Namespace: "billing", Object: "invoices"}, Status{Result: InvalidSpec, Message: "invalid"})
+	if event := <-recorder.Events; event != "Warning InvalidSpec invalid" {
+		t.Errorf("Unexpected event %s", event)
+	}
+	for _, action := range clientset.Actions() {
+		if action.GetVerb() == "patch" {
+			t.Errorf("Expected no patch without AnnotateStatus, got %+v", action)
+		}
+	}
+
+	// Objects that no longer exist get nothing
+	kube.ReportStatus(ctx, Endpoint{Kind: "Service", Namespace: "billing", Object: "unknown"}, Status{Result: Indexed})
+	if len(recorder.Events) != 0 {
+		t.Errorf("Expected no events, got %s", <-recorder.Events)
+	}
+}
@@ -0,0 +1,184 @@
+// Package discovery implements the sources from which APIScout discovers APIs
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// handleDeployment re-indexes the services in front of a Deployment when a rollout of the Deployment completes
+func (k *Kubernetes) handleDeployment(ctx context.Context, eventType watch.EventType, deployment *appsv1.Deployment, events chan<- Event) {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	complete := deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.Replicas == replicas &&
+		deployment.Status.AvailableReplicas == replicas
+
+	k.handleWorkload(ctx, eventType, "Deployment", deployment.ObjectMeta, deployment.Spec.Template, complete, events)
+}
+
+// handleStatefulSet re-indexes the services in front of a StatefulSet when a rollout of the StatefulSet completes
+func (k *Kubernetes) handleStatefulSet(ctx context.Context, eventType watch.EventType, statefulSet *appsv1.StatefulSet, events chan<- Event) {
+	replicas := int32(1)
+	if statefulSet.Spec.Replicas != nil {
+		replicas = *statefulSet.Spec.Replicas
+	}
+	complete := statefulSet.Status.ObservedGeneration >= statefulSet.Generation &&
+		statefulSet.Status.UpdateRevision == statefulSet.Status.CurrentRevision &&
+		statefulSet.Status.UpdatedReplicas == replicas &&
+		statefulSet.Status.ReadyReplicas == replicas
+
+	k.handleWorkload(ctx, eventType, "StatefulSet", statefulSet.ObjectMeta, statefulSet.Spec.Template, complete, events)
+}
+
+// handleWorkload keeps track of the pod template of the last completed rollout of a workload. When a rollout of a
+// new pod template completes, the APIs served by the pods of the workload are indexed again, as a new image usually
+// ships a new OpenAPI document without the service itself changing.
+func (k *Kubernetes) handleWorkload(ctx context.Context, eventType watch.EventType, kind string, object metav1.ObjectMeta, template v1.PodTemplateSpec, complete bool, events chan<- Event) {
+	key := kind + "/" + object.Namespace + "/" + object.Name
+
+	switch eventType {
+	case watch.Added, watch.Modified:
+		if !complete {
+			return
+		}
+
+		b, _ := json.Marshal(template)
+		k.mu.Lock()
+		if k.rollouts == nil {
+			k.rollouts = make(map[string]string)
+		}
+		previous, seen := k.rollouts[key]
+		k.rollouts[key] = string(b)
+		k.mu.Unlock()
+
+		// The services are indexed when they are added, so only rollouts that complete while watching matter. A
+		// workload that is added again when the watch is restarted may have rolled out while nothing was watching.
+		if (eventType == watch.Added && !seen) || (seen && previous == string(b)) {
+			return
+		}
+
+		log.Printf("Rollout of %s %s in %s completed\n", kind, object.Name, k.Name())
+		endpoints, err := k.workloadEndpoints(ctx, object.Namespace, template.Labels)
+		if err != nil {
+			log.Printf("Error while looking up services of %s %s: %s", kind, object.Name, err.Error())
+			return
+		}
+		for _, endpoint := range endpoints {
+			events <- Event{Type: Modified, Endpoint: endpoint}
+		}
+	case watch.Deleted:
+		k.mu.Lock()
+		delete(k.rollouts, key)
+		k.mu.Unlock()
+	}
+}
+
+// workloadEndpoints returns the API endpoints in the namespace that are served by pods with the labels. Those are
+// the services carrying the apiscout annotation and the ApiScoutAPI resources that are backed by a service, which
+// select the pods.
+func (k *Kubernetes) workloadEndpoints(ctx context.Context, namespace string, podLabels map[string]string) ([]Endpoint, error) {
+	list, err := k.Clientset.CoreV1().Services(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []Endpoint
+	selecting := make(map[string]bool)
+	for i := range list.Items {
+		service := &list.Items[i]
+		if len(service.Spec.Selector) == 0 || !labels.SelectorFromSet(service.Spec.Selector).Matches(labels.Set(podLabels)) {
+			continue
+		}
+		selecting[service.Name] = true
+		if service.Annotations[annotation] == "true" {
+			endpoints = append(endpoints, k.publicEndpoint(ctx, service))
+		}
+	}
+
+	apis, err := k.serviceAPIs(ctx, namespace, selecting)
+	if err != nil {
+		return nil, err
+	}
+	return append(endpoints, apis...), nil
+}
+
+// serviceAPIs returns the API endpoints of the ApiScoutAPI resources in the namespace that are backed by one of the
+// services
+func (k *Kubernetes) serviceAPIs(ctx context.Context, namespace string, services map[string]bool) ([]Endpoint, error) {
+	if k.Dynamic == nil || len(services) == 0 {
+		return nil, nil
+	}
+	apis, err := k.Dynamic.Resource(apiResource).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	var endpoints []Endpoint
+	for i := range apis.Items {
+		api := &apis.Items[i]
+		if service, _, _ := unstructured.NestedString(api.Object, "spec", "service"); !services[service] {
+			continue
+		}
+		endpoint, err := k.apiEndpoint(ctx, api)
+		if err != nil {
+			log.Printf("Error while resolving %s %s: %s", apiKind, api.GetName(), err.Error())
+			continue
+		}
+		endpoints = append(endpoints, endpoint)
+	}
+
+	return endpoints, nil
+}
+
+// serviceWorkload returns the Deployment or StatefulSet whose pods the service selects as kind/name, together with the
+// image of the first container of its pods. Nothing is returned when the service selects no workload.
+func (k *Kubernetes) serviceWorkload(ctx context.Context, service *v1.Service) (string, string) {
+	if len(service.Spec.Selector) == 0 {
+		return "", ""
+	}
+	selector := labels.SelectorFromSet(service.Spec.Selector)
+
+	deployments, err := k.Clientset.AppsV1().Deployments(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Error while looking up the Deployment of %s: %s", service.Name, err.Error())
+		return "", ""
+	}
+	for _, deployment := range deployments.Items {
+		if selector.Matches(labels.Set(deployment.Spec.Template.Labels)) {
+			return "Deployment/" + deployment.Name, templateImage(deployment.Spec.Template)
+		}
+	}
+
+	statefulSets, err := k.Clientset.AppsV1().StatefulSets(service.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Printf("Error while looking up the StatefulSet of %s: %s", service.Name, err.Error())
+		return "", ""
+	}
+	for _, statefulSet := range statefulSets.Items {
+		if selector.Matches(labels.Set(statefulSet.Spec.Template.Labels)) {
+			return "StatefulSet/" + statefulSet.Name, templateImage(statefulSet.Spec.Template)
+		}
+	}
+
+	return "", ""
+}
+
+// templateImage returns the image of the first container of a pod template
+func templateImage(template v1.PodTemplateSpec) string {
+	if len(template.Spec.Containers) == 0 {
+		return ""
+	}
+	return template.Spec.Containers[0].Image
+}
@@ -14,7 +14,12 @@ package main
 // The imports
 import (
 	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
 	"github.com/TIBCOSoftware/apiscout/server/server"
 	"github.com/TIBCOSoftware/apiscout/server/util"
 )
@@ -35,6 +40,48 @@ var (
 	externalIP = util.GetEnvKey("EXTERNALIP", "")
 	// The base directory for Hugo
 	hugoDir = util.GetEnvKey("HUGODIR", "")
+	// How to fetch the OpenAPI documents of services (can be either DIRECT, PROXY or ENDPOINTS)
+	fetchMode = util.GetEnvKey("FETCHMODE", "DIRECT")
+	// Which pods to fetch the OpenAPI documents from in ENDPOINTS fetch mode (can be either ALL or FIRST)
+	endpointSelection = util.GetEnvKey("ENDPOINTSELECTION", "ALL")
+	// The interval at which all APIs are fetched again (like 1h), no periodic fetching happens when it is 0
+	refreshInterval = util.GetEnvKey("REFRESHINTERVAL", "0")
+	// The number of OpenAPI documents that are fetched at the same time
+	fetchWorkers = util.GetEnvKey("FETCHWORKERS", "4")
+	// The time after which fetching an OpenAPI document is cancelled (like 30s)
+	fetchTimeout = util.GetEnvKey("FETCHTIMEOUT", "30s")
+	// What to do with OpenAPI documents that have validation problems (can be either WARN or REJECT)
+	validation = util.GetEnvKey("VALIDATION", "WARN")
+	// Whether to convert Swagger 2.0 documents to OpenAPI 3.0
+	convertOAS3 = util.GetEnvKey("CONVERTOAS3", "false")
+	// The maximum number of files an OpenAPI document can reference
+	maxRefFiles = util.GetEnvKey("MAXREFFILES", "50")
+	// The maximum size in bytes of a file referenced by an OpenAPI document
+	maxRefSize = util.GetEnvKey("MAXREFSIZE", "1048576")
+	// The maximum size in bytes of the content the references of an OpenAPI document add to it
+	maxBundleSize = util.GetEnvKey("MAXBUNDLESIZE", "10485760")
+	// The hosts besides the host of an OpenAPI document from which referenced files can be loaded, as a comma separated list
+	refHosts = util.GetEnvKey("REFHOSTS", "")
+	// The file with the ruleset to lint OpenAPI documents with (the default rules are used when empty)
+	ruleset = util.GetEnvKey("RULESET", "")
+	// The file with the rules to redact sensitive data from OpenAPI documents with (nothing is redacted when empty)
+	redactionRules = util.GetEnvKey("REDACTIONRULES", "")
+	// The extension that marks the internal parts of OpenAPI documents, which aren't published (nothing is stripped when empty)
+	internalExtension = util.GetEnvKey("INTERNALEXTENSION", "x-internal")
+	// The directory in which the full documents of APIs with internal parts are stored (they aren't stored when empty)
+	internalStore = util.GetEnvKey("INTERNALSTORE", "")
+	// Whether to record the status of indexing in the apiscout/status annotation of a service
+	annotateStatus = util.GetEnvKey("ANNOTATESTATUS", "false")
+	// Whether to elect a leader among multiple replicas using a Lease
+	leaderElect = util.GetEnvKey("LEADERELECT", "false")
+	// The namespace in which the Lease for leader election is stored
+	podNamespace = util.GetEnvKey("POD_NAMESPACE", "default")
+	// The clusters to watch as a comma separated list of label=context[@kubeconfig] (only MODE is used when empty)
+	clusters = util.GetEnvKey("CLUSTERS", "")
+	// The address of the Consul agent to discover services from (Consul is not used when empty)
+	consulAddr = util.GetEnvKey("CONSULADDR", "")
+	// The tag a service in Consul must have to be indexed
+	consulTag = util.GetEnvKey("CONSULTAG", "apiscout")
 )
 
 // main is the main entrypoint to start APIScout
@@ -52,14 +99,127 @@ func main() {
 	if len(hugoDir) > 0 {
 		log.Printf("Hugo dir         : %s\n", hugoDir)
 	}
+	log.Printf("Fetch mode       : %s\n", fetchMode)
+	if fetchMode == discovery.FetchEndpoints {
+		log.Printf("Endpoints        : %s\n", endpointSelection)
+	}
+	log.Printf("Refresh interval : %s\n", refreshInterval)
+	log.Printf("Fetch workers    : %s\n", fetchWorkers)
+	log.Printf("Fetch timeout    : %s\n", fetchTimeout)
+	log.Printf("Validation       : %s\n", validation)
+	log.Printf("Convert to OAS3  : %s\n", convertOAS3)
+	log.Printf("Max ref files    : %s\n", maxRefFiles)
+	log.Printf("Max ref size     : %s\n", maxRefSize)
+	log.Printf("Max bundle size  : %s\n", maxBundleSize)
+	if len(refHosts) > 0 {
+		log.Printf("Ref hosts        : %s\n", refHosts)
+	}
+	if len(ruleset) > 0 {
+		log.Printf("Ruleset          : %s\n", ruleset)
+	}
+	if len(redactionRules) > 0 {
+		log.Printf("Redaction rules  : %s\n", redactionRules)
+	}
+	log.Printf("Internal ext     : %s\n", internalExtension)
+	if len(internalStore) > 0 {
+		log.Printf("Internal store   : %s\n", internalStore)
+	}
+	log.Printf("Annotate status  : %s\n", annotateStatus)
+	log.Printf("Leader election  : %s\n", leaderElect)
+	if len(clusters) > 0 {
+		log.Printf("Clusters         : %s\n", clusters)
+	}
+	if len(consulAddr) > 0 {
+		log.Printf("Consul address   : %s\n", consulAddr)
+		log.Printf("Consul tag       : %s\n", consulTag)
+	}
 	log.Printf("------------------------------------------------------------\n")
 
 	// Create a new APIScout server instance
-	srv, err := server.New(swaggerStore, hugoStore, runMode, externalIP, hugoDir)
+	srv, err := server.New(swaggerStore, hugoStore, hugoDir)
+	if err != nil {
+		panic(err.Error())
+	}
+	srv.RefreshInterval, err = time.ParseDuration(refreshInterval)
+	if err != nil {
+		panic(err.Error())
+	}
+	srv.Workers, err = strconv.Atoi(fetchWorkers)
+	if err != nil {
+		panic(err.Error())
+	}
+	srv.FetchTimeout, err = time.ParseDuration(fetchTimeout)
+	if err != nil {
+		panic(err.Error())
+	}
+	srv.Validation = validation
+	srv.ConvertToOpenAPI3 = convertOAS3 == "true"
+	srv.MaxRefFiles, err = strconv.Atoi(maxRefFiles)
+	if err != nil {
+		panic(err.Error())
+	}
+	srv.MaxRefSize, err = strconv.Atoi(maxRefSize)
 	if err != nil {
 		panic(err.Error())
 	}
+	srv.MaxBundleSize, err = strconv.Atoi(maxBundleSize)
+	if err != nil {
+		panic(err.Error())
+	}
+	if len(refHosts) > 0 {
+		for _, host := range strings.Split(refHosts, ",") {
+			srv.RefHosts = append(srv.RefHosts, strings.TrimSpace(host))
+		}
+	}
+	if len(ruleset) > 0 {
+		srv.Ruleset, err = util.LoadRuleset(ruleset)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	if len(redactionRules) > 0 {
+		srv.Redaction, err = util.LoadRedactionRules(redactionRules)
+		if err != nil {
+			panic(err.Error())
+		}
+	}
+	srv.InternalExtension = internalExtension
+	srv.InternalStore = internalStore
+
+	// Register Kubernetes as a source to discover APIs from, either a single cluster or each of the clusters
+	var kubes []*discovery.Kubernetes
+	if len(clusters) > 0 {
+		kubes, err = discovery.ParseClusters(clusters)
+		if err != nil {
+			panic(err.Error())
+		}
+	} else {
+		kube, err := discovery.NewKubernetes(runMode, externalIP)
+		if err != nil {
+			panic(err.Error())
+		}
+		kubes = append(kubes, kube)
+	}
+	for _, kube := range kubes {
+		kube.AnnotateStatus = annotateStatus == "true"
+		kube.FetchMode = fetchMode
+		kube.EndpointSelection = endpointSelection
+		srv.AddSource(kube)
+	}
+
+	// Register Consul as a source to discover APIs from
+	if len(consulAddr) > 0 {
+		srv.AddSource(discovery.NewConsul(consulAddr, consulTag))
+	}
 
-	// Start APIScout server
-	srv.Start()
+	// Start APIScout server, when leader election is enabled the Lease is stored in the first cluster
+	if leaderElect == "true" {
+		identity, err := os.Hostname()
+		if err != nil {
+			panic(err.Error())
+		}
+		srv.StartWithLeaderElection(kubes[0].Clientset, podNamespace, util.GetEnvKey("POD_NAME", identity))
+	} else {
+		srv.Start()
+	}
 }
@@ -2,17 +2,47 @@
 package server
 
 import (
-	"reflect"
+	"log"
 
-	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
 )
 
-// handleEvent takes care of the event itself and forwards the event in case it is a service
-func (srv *Server) handleEvent(event watch.Event) {
-	v := &v1.Service{}
-	if reflect.TypeOf(event.Object) == reflect.TypeOf(v) {
-		i := reflect.ValueOf(event.Object).Interface()
-		srv.handleService(i.(*v1.Service), event.Type, 0)
+// handleEvent takes care of the event coming from any of the sources and queues the API endpoint to be handled
+func (srv *Server) handleEvent(event discovery.Event) {
+	srv.dispatch(event.Endpoint, event.Type, 0)
+}
+
+// dispatch queues the API endpoint to be handled by one of the workers. Before the server runs, the API endpoint
+// is handled right away, and after it stopped running the API endpoint is dropped.
+func (srv *Server) dispatch(endpoint discovery.Endpoint, eventType discovery.EventType, retryCount int) {
+	srv.mu.Lock()
+	q, ctx := srv.queue, srv.ctx
+	srv.mu.Unlock()
+
+	// Nothing is handled anymore once the server stopped running, like when the replica is no longer the leader
+	if ctx.Err() != nil {
+		log.Printf("Dropping %s for %s, as the server stopped running\n", eventType, endpoint.Name)
+		return
+	}
+	if q == nil {
+		srv.handleService(endpoint, eventType, retryCount)
+		return
+	}
+	q.add(job{endpoint: endpoint, eventType: eventType, retryCount: retryCount})
+}
+
+// refresh fetches the OpenAPI documents of all indexed APIs again, so changes to an API that didn't cause an event
+// in any of the sources still end up in the catalog
+func (srv *Server) refresh() {
+	srv.mu.Lock()
+	endpoints := make([]discovery.Endpoint, 0, len(srv.indexed))
+	for _, api := range srv.indexed {
+		endpoints = append(endpoints, api.endpoint)
+	}
+	srv.mu.Unlock()
+
+	log.Printf("Refreshing %d APIs\n", len(endpoints))
+	for _, endpoint := range endpoints {
+		srv.dispatch(endpoint, discovery.Modified, 0)
 	}
 }
@@ -0,0 +1,60 @@
+// Package server implements the server of APIScout
+package server
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
+	"github.com/TIBCOSoftware/apiscout/server/util"
+)
+
+// The interval in which the health of the clusters is checked
+const healthInterval = 30 * time.Second
+
+// watchHealth periodically checks the health of all clusters and updates the section of a cluster in the site
+// when its health has changed, until the context is cancelled
+func (srv *Server) watchHealth(ctx context.Context) {
+	reported := make(map[string]discovery.Health)
+
+	for {
+		changed := false
+		for _, cluster := range srv.clusters {
+			health := cluster.Health()
+			if previous, ok := reported[cluster.Cluster]; ok && previous == health {
+				continue
+			}
+
+			log.Printf("Health of cluster %s: %s\n", cluster.Cluster, health.Message)
+			err := util.WriteSectionToDisk(cluster.Cluster, cluster.Cluster, healthMarkdown(health), srv.HugoStore)
+			if err != nil {
+				log.Printf("Error while writing section for cluster %s: %s", cluster.Cluster, err.Error())
+				continue
+			}
+			reported[cluster.Cluster] = health
+			changed = true
+		}
+
+		// Generate the Hugo documentation
+		if changed {
+			srv.generateDocs()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(healthInterval):
+		}
+	}
+}
+
+// healthMarkdown renders the health of a cluster as Markdown for its section in the site
+func healthMarkdown(health discovery.Health) string {
+	status := "Unhealthy"
+	if health.Healthy {
+		status = "Healthy"
+	}
+	return fmt.Sprintf("**Status:** %s since %s\n\n%s\n\n{{%% children %%}}\n", status, health.Since.Format(time.RFC1123), health.Message)
+}
@@ -0,0 +1,70 @@
+// Package server implements the server of APIScout
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+const (
+	// The name of the Lease that is used to elect the leader
+	leaseName = "apiscout"
+	// The time followers wait before they try to acquire a Lease that isn't renewed
+	leaseDuration = 15 * time.Second
+	// The time the leader keeps trying to renew the Lease before it gives up leadership
+	renewDeadline = 10 * time.Second
+	// The time between attempts to acquire or renew the Lease
+	retryPeriod = 2 * time.Second
+)
+
+// StartWithLeaderElection starts the APIScout server as one of multiple replicas. Only the replica that holds the
+// Lease in the namespace watches the sources and generates the site, while the other replicas wait to take over.
+func (srv *Server) StartWithLeaderElection(clientset kubernetes.Interface, namespace string, identity string) {
+	// Keep participating in the election, so a replica that lost the Lease can become the leader again
+	for {
+		srv.lead(context.Background(), clientset, namespace, identity)
+	}
+}
+
+// lead participates in the election for the Lease until the context is cancelled or the replica loses the Lease.
+// While the replica is the leader, the server runs with a context that is cancelled when it loses the Lease.
+func (srv *Server) lead(ctx context.Context, clientset kubernetes.Interface, namespace string, identity string) {
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      leaseName,
+			Namespace: namespace,
+		},
+		Client: clientset.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: identity,
+		},
+	}
+
+	leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		ReleaseOnCancel: true,
+		LeaseDuration:   leaseDuration,
+		RenewDeadline:   renewDeadline,
+		RetryPeriod:     retryPeriod,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("%s is the leader, starting to watch sources\n", identity)
+				srv.run(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("%s is no longer the leader\n", identity)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != identity {
+					log.Printf("%s is the leader, waiting to take over\n", leader)
+				}
+			},
+		},
+	})
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// fakeSource sends its API endpoints as added every time it is watched, and fetches the same document for all of them
+type fakeSource struct {
+	endpoints []discovery.Endpoint
+	watched   int32
+}
+
+func (s *fakeSource) Name() string {
+	return "fake"
+}
+
+func (s *fakeSource) Watch(ctx context.Context, events chan<- discovery.Event) error {
+	atomic.AddInt32(&s.watched, 1)
+	for _, endpoint := range s.endpoints {
+		select {
+		case events <- discovery.Event{Type: discovery.Added, Endpoint: endpoint}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (s *fakeSource) Fetch(ctx context.Context, endpoint discovery.Endpoint) (string, []string, error) {
+	return swaggerJSONPayload, nil, nil
+}
+
+// waitFor waits until the condition is true, failing the test when that takes too long
+func waitFor(t *testing.T, what string, condition func() bool) {
+	for i := 0; i < 500; i++ {
+		if condition() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+// newTestServer returns a server that writes to a temporary directory with the fake source
+func newTestServer(t *testing.T, source *fakeSource) (*Server, func()) {
+	dir, err := ioutil.TempDir("", "apiscout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := New(dir, dir, dir)
+	srv.AddSource(source)
+	return srv, func() { os.RemoveAll(dir) }
+}
+
+func TestRunTerms(t *testing.T) {
+	endpoint := discovery.Endpoint{Name: "invoices", Source: "fake", SpecURL: "http://localhost:8123/swaggerspec", Host: "localhost:8123"}
+	source := &fakeSource{endpoints: []discovery.Endpoint{endpoint}}
+	srv, cleanup := newTestServer(t, source)
+	defer cleanup()
+
+	// The first term indexes what the source sends
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.run(ctx)
+		close(done)
+	}()
+	waitFor(t, "the API to be indexed", func() bool { return srv.isIndexed(endpoint) })
+	cancel()
+	<-done
+
+	// Once the term ended, events and retries are dropped instead of written to the site
+	page := filepath.Join(srv.HugoStore, endpoint.PageName()+".md")
+	os.Remove(page)
+	srv.dispatch(endpoint, discovery.Modified, 0)
+	srv.retry(endpoint, discovery.Modified, 0)
+	if _, err := os.Stat(page); err == nil {
+		t.Fatal("Expected nothing to be written after the term ended")
+	}
+	ioutil.WriteFile(page, []byte("removed while following"), 0644)
+
+	// The next term starts with an empty catalog and watches the sources again
+	source.endpoints = nil
+	ctx, cancel = context.WithCancel(context.Background())
+	done = make(chan struct{})
+	go func() {
+		srv.run(ctx)
+		close(done)
+	}()
+	waitFor(t, "the source to be watched again", func() bool { return atomic.LoadInt32(&source.watched) == 2 })
+	if srv.isIndexed(endpoint) {
+		t.Error("Expected the APIs of the previous term to be forgotten")
+	}
+	if _, err := os.Stat(page); err == nil {
+		t.Error("Expected the pages of the previous term to be removed")
+	}
+	cancel()
+	<-done
+}
+
+func TestLead(t *testing.T) {
+	source := &fakeSource{}
+	srv, cleanup := newTestServer(t, source)
+	defer cleanup()
+	clientset := fake.NewSimpleClientset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		srv.lead(ctx, clientset, "default", "replica-1")
+		close(done)
+	}()
+
+	// The replica acquires the Lease and starts watching the sources
+	waitFor(t, "the sources to be watched", func() bool { return atomic.LoadInt32(&source.watched) == 1 })
+	lease, err := clientset.CoordinationV1().Leases("default").Get(context.Background(), leaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != "replica-1" {
+		t.Fatalf("Expected replica-1 to hold the Lease, got %v", lease.Spec.HolderIdentity)
+	}
+
+	// The Lease is released when the replica stops, so another replica can take over right away
+	cancel()
+	<-done
+	lease, err = clientset.CoordinationV1().Leases("default").Get(context.Background(), leaseName, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Spec.HolderIdentity != nil && len(*lease.Spec.HolderIdentity) > 0 {
+		t.Errorf("Expected the Lease to be released, got %s", *lease.Spec.HolderIdentity)
+	}
+}
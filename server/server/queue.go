@@ -0,0 +1,78 @@
+// Package server implements the server of APIScout
+package server
+
+import (
+	"sync"
+
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
+)
+
+// job is an event for an API endpoint that is waiting to be handled
+type job struct {
+	endpoint   discovery.Endpoint
+	eventType  discovery.EventType
+	retryCount int
+}
+
+// queue handles the events for API endpoints in separate go routines, so a slow service doesn't delay the events of
+// other services. At most a fixed number of events are handled at the same time, and the events of a single API
+// endpoint are handled one after the other in the order they came in.
+type queue struct {
+	// The function that handles a job
+	handle func(job)
+	// The semaphore that limits the number of jobs that are handled at the same time
+	workers chan struct{}
+	// The jobs waiting for an earlier job of the same API endpoint to finish, by the key of the API endpoint
+	pending map[string][]job
+	// The mutex to guard the pending jobs
+	mu sync.Mutex
+}
+
+// newQueue creates a new queue that handles at most workers jobs at the same time
+func newQueue(workers int, handle func(job)) *queue {
+	if workers < 1 {
+		workers = 1
+	}
+	return &queue{
+		handle:  handle,
+		workers: make(chan struct{}, workers),
+		pending: make(map[string][]job),
+	}
+}
+
+// add queues a job without waiting for it to be handled
+func (q *queue) add(j job) {
+	key := j.endpoint.Key()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// An earlier job of the API endpoint is still running and picks this one up when it's done
+	if jobs, ok := q.pending[key]; ok {
+		q.pending[key] = append(jobs, j)
+		return
+	}
+	q.pending[key] = nil
+
+	go q.run(key, j)
+}
+
+// run handles the job and then the jobs of the same API endpoint that were queued in the meantime
+func (q *queue) run(key string, j job) {
+	for {
+		q.workers <- struct{}{}
+		q.handle(j)
+		<-q.workers
+
+		q.mu.Lock()
+		jobs := q.pending[key]
+		if len(jobs) == 0 {
+			delete(q.pending, key)
+			q.mu.Unlock()
+			return
+		}
+		j = jobs[0]
+		q.pending[key] = jobs[1:]
+		q.mu.Unlock()
+	}
+}
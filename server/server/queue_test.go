@@ -0,0 +1,53 @@
+package server
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
+)
+
+func TestQueue(t *testing.T) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	running, maxRunning := 0, 0
+	handled := make(map[string][]discovery.EventType)
+
+	q := newQueue(2, func(j job) {
+		mu.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mu.Unlock()
+
+		// A slow service
+		if j.endpoint.Name == "slow" {
+			time.Sleep(50 * time.Millisecond)
+		}
+
+		mu.Lock()
+		running--
+		handled[j.endpoint.Name] = append(handled[j.endpoint.Name], j.eventType)
+		mu.Unlock()
+		wg.Done()
+	})
+
+	wg.Add(6)
+	q.add(job{endpoint: discovery.Endpoint{Name: "slow"}, eventType: discovery.Added})
+	q.add(job{endpoint: discovery.Endpoint{Name: "slow"}, eventType: discovery.Modified})
+	q.add(job{endpoint: discovery.Endpoint{Name: "slow"}, eventType: discovery.Deleted})
+	for _, name := range []string{"a", "b", "c"} {
+		q.add(job{endpoint: discovery.Endpoint{Name: name}, eventType: discovery.Added})
+	}
+	wg.Wait()
+
+	if maxRunning > 2 {
+		t.Fatalf("Expected at most 2 jobs at the same time, got %d", maxRunning)
+	}
+	slow := handled["slow"]
+	if len(slow) != 3 || slow[0] != discovery.Added || slow[1] != discovery.Modified || slow[2] != discovery.Deleted {
+		t.Fatalf("Expected the events of a single API endpoint in order, got %v", slow)
+	}
+}
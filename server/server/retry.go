@@ -5,8 +5,7 @@ import (
 	"log"
 	"time"
 
-	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/watch"
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
 )
 
 const maxRetryCount = 3
@@ -14,13 +13,20 @@ const maxRetryCount = 3
 // retry takes a nap for 30 seconds in a separate go routine and retries the service. Usually when a service is created
 // when the server component of the app isn't fully started (like on initial deployment), the server would respond with
 // a dial timeout and it should be retried
-func (srv *Server) retry(service *v1.Service, eventType watch.EventType, retryCount int) {
+func (srv *Server) retry(endpoint discovery.Endpoint, eventType discovery.EventType, retryCount int) {
 	if retryCount < maxRetryCount {
+		ctx := srv.context()
 		go func() {
-			log.Printf("Retrying %s in 30 seconds...", service.Name)
-			time.Sleep(30000 * time.Millisecond)
-			log.Printf("Retrying %s with current retryCount %d...", service.Name, retryCount)
-			srv.handleService(service, eventType, retryCount+1)
+			log.Printf("Retrying %s in 30 seconds...", endpoint.Name)
+			// The retry is dropped when the server stops running in the meantime
+			select {
+			case <-time.After(30000 * time.Millisecond):
+			case <-ctx.Done():
+				log.Printf("Not retrying %s, as the server stopped running", endpoint.Name)
+				return
+			}
+			log.Printf("Retrying %s with current retryCount %d...", endpoint.Name, retryCount)
+			srv.dispatch(endpoint, eventType, retryCount+1)
 		}()
 	}
 }
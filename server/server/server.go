@@ -2,14 +2,17 @@
 package server
 
 import (
+	"context"
+	"io/ioutil"
+	"log"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
 	"github.com/TIBCOSoftware/apiscout/server/util"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/clientcmd"
 )
 
 // Server represents the APIScout server and implements methods.
@@ -20,64 +23,266 @@ type Server struct {
 	SwaggerStore string
 	// The location where to store content for Hugo
 	HugoStore string
-	// The mode in which apiscout is running (can be either KUBE or LOCAL)
-	RunMode string
-	// The external IP address of the Kubernetes cluster in case of LOCAL mode
-	ExternalIP string
 	// The base directory for Hugo
 	HugoDir string
+	// The interval at which all indexed APIs are fetched again, no periodic fetching happens when it is zero
+	RefreshInterval time.Duration
+	// The number of OpenAPI documents that are fetched at the same time
+	Workers int
+	// The time after which fetching an OpenAPI document is cancelled
+	FetchTimeout time.Duration
+	// What to do with OpenAPI documents that have validation problems (can be either WARN or REJECT)
+	Validation string
+	// Whether to convert Swagger 2.0 documents to OpenAPI 3.0 before publishing them
+	ConvertToOpenAPI3 bool
+	// The ruleset to lint OpenAPI documents with, the default rules are used when it is nil
+	Ruleset *util.Ruleset
+	// The rules to redact sensitive data from OpenAPI documents before they are published, nothing is redacted when nil
+	Redaction *util.RedactionRules
+	// The extension that marks the internal parts of OpenAPI documents, which aren't published (nothing is stripped when empty)
+	InternalExtension string
+	// The directory in which the full documents of APIs with internal parts are stored for a restricted audience,
+	// they aren't stored when empty
+	InternalStore string
+	// The maximum number of files an OpenAPI document can reference
+	MaxRefFiles int
+	// The maximum size in bytes of a file referenced by an OpenAPI document
+	MaxRefSize int
+	// The maximum size in bytes of the content the references of an OpenAPI document add to it when it is bundled
+	MaxBundleSize int
+	// The hosts besides the host of an OpenAPI document from which the files it references can be loaded
+	RefHosts []string
+	// The sources from which APIs are discovered
+	Sources []discovery.Source
+	// The APIs that have been indexed, by the key of the API endpoint
+	indexed map[string]indexedAPI
+	// The Kubernetes sources that watch a labeled cluster
+	clusters []*discovery.Kubernetes
+	// The sources that record the status of indexing, by the name of the source
+	reporters map[string]discovery.StatusReporter
+	// The sources that fetch OpenAPI documents themselves, by the name of the source
+	fetchers map[string]discovery.Fetcher
+	// The sources that load the files referenced by OpenAPI documents themselves, by the name of the source
+	loaders map[string]discovery.RefLoader
+	// The queue of API endpoints waiting to be handled, nil until the server runs
+	queue *queue
+	// The context of the current run, which is cancelled when the server stops running (like when the replica is no
+	// longer the leader)
+	ctx context.Context
+	// The mutex to guard the service map, the indexed APIs, the queue and the context
+	mu sync.Mutex
+	// The mutex to make sure the site is generated only once at a time
+	generating sync.Mutex
+	// The mutex to make sure the sources are only watched once at a time
+	running sync.Mutex
+}
+
+const (
+	// The default number of OpenAPI documents that are fetched at the same time
+	defaultWorkers = 4
+	// The default time after which fetching an OpenAPI document is cancelled
+	defaultFetchTimeout = 30 * time.Second
+	// The default maximum number of files an OpenAPI document can reference
+	defaultMaxRefFiles = 50
+	// The default maximum size in bytes of a file referenced by an OpenAPI document
+	defaultMaxRefSize = 1024 * 1024
+	// The default maximum size in bytes of the content the references of an OpenAPI document add to it
+	defaultMaxBundleSize = 10 * 1024 * 1024
+	// The default extension that marks the internal parts of OpenAPI documents
+	defaultInternalExtension = "x-internal"
+)
+
+// The interval at which the pages that list the APIs are written again, so a sunset moves from the upcoming to the
+// passed sunsets within an hour after it passed, even when no API is indexed or removed
+const listingsInterval = time.Hour
+
+const (
+	// ValidationWarn publishes OpenAPI documents with validation problems, showing the problems on their page
+	ValidationWarn = "WARN"
+	// ValidationReject doesn't publish OpenAPI documents with validation problems
+	ValidationReject = "REJECT"
+)
+
+// indexedAPI is an API endpoint that has been indexed, together with what was published for it
+type indexedAPI struct {
+	// The API endpoint
+	endpoint discovery.Endpoint
+	// The hash of the OpenAPI document that was published, as it was fetched
+	hash string
+	// What was published on the page of the API besides the OpenAPI document, like the validation problems
+	page util.Page
+	// The quality score of the OpenAPI document that was published
+	score util.Score
 }
 
 // New creates a new instance of the Server
-func New(swaggerStore string, hugoStore string, runMode string, externalIP string, hugoDir string) (*Server, error) {
+func New(swaggerStore string, hugoStore string, hugoDir string) (*Server, error) {
 	// Return a new struct
 	return &Server{
-		ServiceMap:   make(map[string]string),
-		SwaggerStore: swaggerStore,
-		HugoStore:    hugoStore,
-		RunMode:      runMode,
-		ExternalIP:   externalIP,
-		HugoDir:      hugoDir,
+		ServiceMap:        make(map[string]string),
+		SwaggerStore:      swaggerStore,
+		HugoStore:         hugoStore,
+		HugoDir:           hugoDir,
+		Workers:           defaultWorkers,
+		FetchTimeout:      defaultFetchTimeout,
+		Validation:        ValidationWarn,
+		MaxRefFiles:       defaultMaxRefFiles,
+		MaxRefSize:        defaultMaxRefSize,
+		MaxBundleSize:     defaultMaxBundleSize,
+		InternalExtension: defaultInternalExtension,
+		ctx:               context.Background(),
+		indexed:           make(map[string]indexedAPI),
+		reporters:         make(map[string]discovery.StatusReporter),
+		fetchers:          make(map[string]discovery.Fetcher),
+		loaders:           make(map[string]discovery.RefLoader),
 	}, nil
 }
 
+// AddSource registers a source from which the server discovers APIs
+func (srv *Server) AddSource(source discovery.Source) {
+	srv.Sources = append(srv.Sources, source)
+
+	// Sources that record the status of indexing get the results of the APIs they discovered
+	if reporter, ok := source.(discovery.StatusReporter); ok {
+		srv.reporters[source.Name()] = reporter
+	}
+
+	// Sources that fetch OpenAPI documents themselves are used to fetch the APIs they discovered
+	if fetcher, ok := source.(discovery.Fetcher); ok {
+		srv.fetchers[source.Name()] = fetcher
+	}
+
+	// Sources that load referenced files themselves are used to load the files referenced by the APIs they discovered
+	if loader, ok := source.(discovery.RefLoader); ok {
+		srv.loaders[source.Name()] = loader
+	}
+
+	// Sources that watch a labeled cluster get their own section in the site, showing the health of the cluster
+	if kube, ok := source.(*discovery.Kubernetes); ok && len(kube.Cluster) > 0 {
+		srv.clusters = append(srv.clusters, kube)
+	}
+}
+
 // Start is the main engine to start the APIScout server
 func (srv *Server) Start() {
-	var config *rest.Config
-	var err error
+	srv.run(context.Background())
+}
 
-	if strings.ToUpper(srv.RunMode) == "KUBE" {
-		// Create the Kubernetes in-cluster config
-		config, err = rest.InClusterConfig()
-		if err != nil {
-			panic(err.Error())
-		}
-	} else {
-		// use the current context in kubeconfig
-		config, err = clientcmd.BuildConfigFromFlags("", filepath.Join(util.HomeDir(), ".kube", "config"))
-		if err != nil {
-			panic(err.Error())
+// run watches all sources and handles their events until the context is cancelled
+func (srv *Server) run(ctx context.Context) {
+	srv.running.Lock()
+	defer srv.running.Unlock()
+
+	// Start with an empty service map and empty stores, so all APIs the sources report are indexed and the pages of
+	// APIs that were removed while another replica was the leader aren't published again
+	srv.mu.Lock()
+	srv.ServiceMap = make(map[string]string)
+	srv.indexed = make(map[string]indexedAPI)
+	srv.ctx = ctx
+	srv.queue = newQueue(srv.Workers, func(j job) {
+		// The jobs that are still queued when the run ends are dropped
+		if ctx.Err() != nil {
+			return
 		}
+		srv.handleService(j.endpoint, j.eventType, j.retryCount)
+	})
+	srv.clearStores()
+	srv.mu.Unlock()
+
+	// Generate the site right away, so it no longer shows the APIs of an earlier term and the followers serve a site
+	// from the shared volume even before any API is indexed
+	srv.rewriteListings()
+
+	events := make(chan discovery.Event)
+
+	// Start watching all sources, each in a separate go routine
+	var wg sync.WaitGroup
+	for _, source := range srv.Sources {
+		wg.Add(1)
+		go func(source discovery.Source) {
+			defer wg.Done()
+			log.Printf("Starting to watch source %s\n", source.Name())
+			if err := source.Watch(ctx, events); err != nil {
+				log.Printf("Source %s stopped: %s\n", source.Name(), err.Error())
+			}
+		}(source)
 	}
 
-	// Create the clientset
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		panic(err.Error())
+	stopped := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stopped)
+	}()
+
+	// Keep the health of the clusters up to date
+	if len(srv.clusters) > 0 {
+		go srv.watchHealth(ctx)
 	}
 
-	// Create a watcher
-	watcher, err := clientset.CoreV1().Services("").Watch(metav1.ListOptions{})
-	if err != nil {
-		panic(err.Error())
+	// Fetch all APIs periodically to pick up changes that aren't visible as an event
+	var refresh <-chan time.Time
+	if srv.RefreshInterval > 0 {
+		ticker := time.NewTicker(srv.RefreshInterval)
+		defer ticker.Stop()
+		refresh = ticker.C
 	}
 
-	// Create a channel for the events to come in from the watcher
-	eventChannel := watcher.ResultChan()
+	// Write the listings periodically, as sunsets pass without an event
+	listings := time.NewTicker(listingsInterval)
+	defer listings.Stop()
 
-	// Start an indefinite loop
+	// Start a loop that runs until the context is cancelled
 	for {
-		evt := <-eventChannel
-		srv.handleEvent(evt)
+		select {
+		case evt := <-events:
+			srv.handleEvent(evt)
+		case <-refresh:
+			srv.refresh()
+		case <-listings.C:
+			srv.rewriteListings()
+		case <-ctx.Done():
+			// Stop handling API endpoints, the retries and the jobs that are still queued are dropped
+			srv.mu.Lock()
+			srv.queue = nil
+			srv.mu.Unlock()
+
+			// Discard the events that are still sent until all sources have stopped
+			for {
+				select {
+				case <-events:
+				case <-stopped:
+					return
+				}
+			}
+		}
+	}
+}
+
+// context returns the context of the current run, which is cancelled when the server stops running
+func (srv *Server) context() context.Context {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return srv.ctx
+}
+
+// clearStores removes the documents and pages of all APIs from the stores, keeping the section of the Hugo store
+// itself and hidden files (like .gitkeep). The caller must hold the mutex.
+func (srv *Server) clearStores() {
+	for _, store := range []string{srv.SwaggerStore, srv.HugoStore, srv.InternalStore} {
+		if len(store) == 0 {
+			continue
+		}
+		files, err := ioutil.ReadDir(store)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if file.Name() == "_index.md" || strings.HasPrefix(file.Name(), ".") {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(store, file.Name())); err != nil {
+				log.Printf("Error while clearing %s: %s", store, err.Error())
+			}
+		}
 	}
 }
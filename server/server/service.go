@@ -2,131 +2,497 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"time"
 
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
 	"github.com/TIBCOSoftware/apiscout/server/util"
-	"k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/watch"
 )
 
-const (
-	// The annotation for apiscout to index a service
-	annotation = "apiscout/index"
-	// The annotation for apiscout to get the OpenAPI doc from
-	swaggerURL = "apiscout/swaggerUrl"
-)
-
-// handleService takes the Kubernetes service object and the EventType as input to determine what
-// to do with the event
-func (srv *Server) handleService(service *v1.Service, eventType watch.EventType, retryCount int) {
-	log.Printf("Received %s for %s\n", eventType, service.Name)
+// handleService takes the API endpoint and the EventType as input to determine what to do with the event
+func (srv *Server) handleService(endpoint discovery.Endpoint, eventType discovery.EventType, retryCount int) {
+	log.Printf("Received %s for %s from %s\n", eventType, endpoint.Name, endpoint.Source)
 
 	switch eventType {
-	case watch.Added:
-		if service.Annotations[annotation] == "true" {
-			err := add(service, srv)
-			if err != nil {
-				if strings.Contains(err.Error(), "dial tcp") {
-					srv.retry(service, eventType, retryCount+1)
-				} else {
-					log.Println(err.Error())
-					return
-				}
+	case discovery.Added:
+		err := add(endpoint, srv)
+		if err != nil {
+			if retryable(err) {
+				srv.retry(endpoint, eventType, retryCount+1)
+			} else {
+				log.Println(err.Error())
+				return
 			}
 		}
-	case watch.Deleted:
-		err := remove(service, srv)
+	case discovery.Deleted:
+		err := remove(endpoint, srv)
 		if err != nil {
 			log.Println(err.Error())
 			return
 		}
-	case watch.Modified:
-		err := remove(service, srv)
+	case discovery.Modified:
+		changed, err := index(endpoint, srv)
 		if err != nil {
-			log.Println(err.Error())
-		}
-		if service.Annotations[annotation] == "true" {
-			err := add(service, srv)
-			if err != nil {
-				if strings.Contains(err.Error(), "dial tcp") {
-					srv.retry(service, eventType, retryCount+1)
-				} else {
+			// The last document that was indexed stays published when fetching fails, so an API doesn't disappear
+			// while its service is unavailable. Only a document that is rejected as invalid is removed.
+			if _, ok := err.(rejectedError); ok && srv.isIndexed(endpoint) {
+				if err := remove(endpoint, srv); err != nil {
 					log.Println(err.Error())
-					return
 				}
 			}
+			if retryable(err) {
+				srv.retry(endpoint, eventType, retryCount+1)
+			} else {
+				log.Println(err.Error())
+				return
+			}
+		} else if !changed {
+			// Nothing was written, so there is no need to regenerate the site
+			return
 		}
-	case watch.Error:
-		log.Println("Received watch.EventType Error, this is not recommended to be handled so API Scout will ignore")
-		return
 	default:
-		log.Printf("Received unknown watch.EventType %s, so API Scout will ignore\n", eventType)
+		log.Printf("Received unknown EventType %s, so API Scout will ignore\n", eventType)
+		return
+	}
+
+	// Generate the Hugo documentation, unless the server stopped running in the meantime
+	if srv.context().Err() != nil {
 		return
 	}
+	srv.generateDocs()
+}
+
+// generateDocs regenerates the Hugo documentation, making sure Hugo doesn't run multiple times at once
+func (srv *Server) generateDocs() {
+	srv.generating.Lock()
+	defer srv.generating.Unlock()
 
-	// Generate the Hugo documentation
 	err := util.GenerateDocs(srv.HugoDir)
 	if err != nil {
 		log.Printf("Error while attemtping to regenerate Hugo content: %s", err.Error())
 	}
 }
 
-// add adds a service to the service map and generates the developer documentation if it doesn't exist in the service map yet
-func add(service *v1.Service, srv *Server) error {
-	if _, ok := srv.ServiceMap[service.Name]; !ok {
-		log.Printf("%s should be indexed from %s\n", service.Name, service.Annotations[swaggerURL])
+// isIndexed checks whether the API endpoint is in the service map
+func (srv *Server) isIndexed(endpoint discovery.Endpoint) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	_, ok := srv.ServiceMap[endpoint.Key()]
+	return ok
+}
+
+// add adds an API endpoint to the service map and generates the developer documentation if it doesn't exist in the service map yet
+func add(endpoint discovery.Endpoint, srv *Server) error {
+	if !srv.isIndexed(endpoint) {
+		_, err := index(endpoint, srv)
+		return err
+	}
+
+	return nil
+}
+
+// index fetches the OpenAPI document of the API endpoint and generates the developer documentation for it. When
+// exactly the same document was already published for the API endpoint, nothing is written and false is returned.
+func index(endpoint discovery.Endpoint, srv *Server) (bool, error) {
+	log.Printf("%s should be indexed from %s\n", endpoint.Name, endpoint.SpecURL)
+
+	apidoc, notices, err := srv.fetch(endpoint)
+	if err != nil {
+		log.Printf("Error while retrieving API document from %s: %s", endpoint.SpecURL, err.Error())
+		message := err.Error()
+		if srv.isIndexed(endpoint) {
+			message = fmt.Sprintf("%s, so the OpenAPI document that was indexed before is kept", message)
+		}
+		srv.report(endpoint, discovery.Status{Result: discovery.FetchFailed, Message: message})
+		return false, err
+	}
+
+	// Hash the document as it was fetched, so the hash only changes when the service serves another document
+	hash := util.Hash(apidoc)
 
-		var ip string
-		var port int32
+	// Point the document to the public URL of the API when it is known
+	if len(endpoint.PublicURL) > 0 {
+		apidoc, err = util.SetPublicURL(apidoc, endpoint.PublicURL)
+		if err != nil {
+			log.Printf("Error while setting public URL of %s: %s", endpoint.Name, err.Error())
+			srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+			return false, err
+		}
+	}
 
-		if len(srv.ExternalIP) > 0 {
-			ip = srv.ExternalIP
-			port = service.Spec.Ports[0].NodePort
+	// Skip writing the document when it is the same as the one that was published for the same API endpoint, which
+	// includes its public URL
+	srv.mu.Lock()
+	previous, ok := srv.indexed[endpoint.Key()]
+	srv.mu.Unlock()
+	if ok && previous.hash == hash &&
+		reflect.DeepEqual(previous.endpoint, endpoint) && reflect.DeepEqual(previous.page.Notices, notices) {
+		log.Printf("The OpenAPI document of %s hasn't changed\n", endpoint.Name)
+		return false, nil
+	}
+
+	// Strip the internal parts of the document, keeping the full document for the restricted audience
+	var full string
+	if len(srv.InternalExtension) > 0 {
+		stripped, ok, err := util.StripInternal(apidoc, srv.InternalExtension)
+		if err != nil {
+			srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+			return false, err
+		}
+		if ok {
+			log.Printf("Stripped the internal parts from the OpenAPI document of %s\n", endpoint.Name)
+			full, apidoc = apidoc, stripped
+		}
+	}
+
+	// Validate the document against its specification
+	page := util.Page{Title: endpoint.Name, Notices: notices}
+	page.Spec, page.Problems = util.Validate(apidoc)
+	var problems []string
+	for _, problem := range page.Problems {
+		problems = append(problems, problem.String())
+	}
+	if len(problems) > 0 {
+		log.Printf("The OpenAPI document of %s has %d validation problems\n", endpoint.Name, len(problems))
+		if strings.ToUpper(srv.Validation) == ValidationReject {
+			err := rejectedError{fmt.Errorf("the OpenAPI document of %s is invalid: %s", endpoint.Name, strings.Join(problems, "; "))}
+			srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error(), Problems: problems})
+			return false, err
+		}
+	}
+
+	// Lint the document with the rules for the namespace of the API
+	page.Lint = util.Lint(apidoc, srv.Ruleset.For(endpoint.Namespace))
+
+	// Convert Swagger 2.0 documents to OpenAPI 3.0, keeping the original next to it
+	if srv.ConvertToOpenAPI3 && page.Spec == util.Swagger2 {
+		converted, err := util.ConvertToOpenAPI3(apidoc, endpoint.Host)
+		if err != nil {
+			log.Printf("Error while converting %s to OpenAPI 3.0: %s", endpoint.Name, err.Error())
+			page.Notices = append(page.Notices, fmt.Sprintf("This API couldn't be converted to OpenAPI 3.0: %s", err.Error()))
 		} else {
-			ip = service.Spec.ClusterIP
-			port = service.Spec.Ports[0].Port
+			page.Original = apidoc
+			apidoc = converted
 		}
+	}
+
+	// Redact sensitive data from the documents that are published
+	apidoc, page.Redactions, err = util.Redact(apidoc, srv.Redaction)
+	if err != nil {
+		srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+		return false, err
+	}
+	// The original of a converted document holds the same data, so only the converted document is reported
+	page.Original, _, err = util.Redact(page.Original, srv.Redaction)
+	if err != nil {
+		srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+		return false, err
+	}
+	// The host of the API is written into Swagger 2.0 documents when they are written to disk, so it is redacted as well
+	host, err := util.RedactHost(endpoint.Host, srv.Redaction)
+	if err != nil {
+		srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+		return false, err
+	}
+
+	// Record what is known about the deployment of the API, keeping the time at which it was first indexed
+	page.Metadata = &util.Metadata{
+		Namespace:  endpoint.Namespace,
+		Cluster:    endpoint.Cluster,
+		Labels:     endpoint.Labels,
+		Workload:   endpoint.Workload,
+		Image:      endpoint.Image,
+		Discovered: time.Now(),
+		SourceURL:  endpoint.SpecURL,
+	}
+	if len(endpoint.Image) > 0 {
+		page.Metadata.ImageTag = util.ImageTag(endpoint.Image)
+	}
+	if ok && previous.page.Metadata != nil {
+		page.Metadata.Discovered = previous.page.Metadata.Discovered
+	}
+
+	// The metadata is published on the page and in the document as well, so it is redacted with the same rules
+	var redactions []util.Redaction
+	page.Metadata, redactions, err = util.RedactMetadata(page.Metadata, srv.Redaction)
+	if err != nil {
+		srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+		return false, err
+	}
+	page.Redactions = append(page.Redactions, redactions...)
+	if len(page.Redactions) > 0 {
+		log.Printf("Redacted %d values from the OpenAPI document of %s\n", len(page.Redactions), endpoint.Name)
+	}
+
+	// Record who to contact about the API, completed with the contact in the document
+	page.Ownership = endpoint.Ownership().MergeContact(apidoc)
+
+	// Record the tags, categories and labels by which the API can be browsed, only using the labels that weren't
+	// redacted from the metadata
+	labeled := endpoint
+	labeled.Labels = nil
+	if page.Metadata != nil {
+		labeled.Labels = page.Metadata.Labels
+	}
+	page.Taxonomies = labeled.Taxonomies(apidoc)
 
-		apidoc, err := util.GetAPIDoc(fmt.Sprintf("http://%s:%d%s", ip, port, service.Annotations[swaggerURL]))
+	// Collect the deprecations of the API and its operations
+	deprecation, sunset := endpoint.Sunset()
+	page.Deprecations = util.FindDeprecations(apidoc, page.Ownership.Lifecycle, deprecation, sunset)
+
+	// Nothing is written once the server stopped running, as another replica can be the leader by now
+	if err := srv.context().Err(); err != nil {
+		return false, fmt.Errorf("not publishing %s, as the server stopped running: %s", endpoint.Name, err.Error())
+	}
+
+	// The full document is redacted like the published document
+	if len(full) > 0 {
+		full, _, err = util.Redact(full, srv.Redaction)
 		if err != nil {
-			log.Printf("Error while retrieving API document from %s: %s", fmt.Sprintf("http://%s:%d%s", ip, port, service.Annotations[swaggerURL]), err.Error())
-			return err
+			srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+			return false, err
 		}
+	}
+
+	// An API that is declared internal is only published to the restricted audience, including its internal parts
+	if endpoint.Internal() {
+		if len(full) == 0 {
+			full = apidoc
+		}
+		return srv.indexInternal(endpoint, full, host, hash, problems)
+	}
 
-		util.WriteSwaggerToDisk(service.Name, apidoc, fmt.Sprintf("%s:%d", ip, port), srv.SwaggerStore, srv.HugoStore)
+	// Publish the full document to the restricted audience, or remove it when the document has no internal parts anymore
+	if len(srv.InternalStore) > 0 {
+		if len(full) > 0 {
+			if err := util.WriteInternalToDisk(endpoint.PageName(), endpoint.Cluster, full, host, srv.InternalStore); err != nil {
+				srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+				return false, err
+			}
+		} else {
+			os.Remove(util.InternalFilename(srv.InternalStore, endpoint.Cluster, endpoint.PageName()))
+		}
+	}
 
-		srv.ServiceMap[service.Name] = "DONE"
-		log.Printf("Service %s has been added to API Scout\n", service.Name)
+	score, err := util.WriteSwaggerToDisk(endpoint.PageName(), endpoint.Cluster, apidoc, host, srv.SwaggerStore, srv.HugoStore, page)
+	if err != nil {
+		srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+		return false, err
 	}
 
-	return nil
+	srv.mu.Lock()
+	srv.ServiceMap[endpoint.Key()] = "DONE"
+	srv.indexed[endpoint.Key()] = indexedAPI{endpoint: endpoint, hash: hash, page: page, score: score}
+	srv.writeListings()
+	srv.mu.Unlock()
+
+	message := fmt.Sprintf("The OpenAPI document was indexed from %s", endpoint.SpecURL)
+	if len(problems) > 0 {
+		message = fmt.Sprintf("The OpenAPI document was indexed from %s with %d validation problems", endpoint.SpecURL, len(problems))
+	}
+	srv.report(endpoint, discovery.Status{
+		Result:   discovery.Indexed,
+		Message:  message,
+		Hash:     hash,
+		Problems: problems,
+	})
+	log.Printf("Service %s has been added to API Scout\n", endpoint.Name)
+
+	return true, nil
+}
+
+// writeListings writes the pages that list the indexed APIs: the leaderboard that ranks them by their quality score,
+// the pages of the teams that own them and the deprecations with their sunsets. The caller must hold the mutex, so the
+// pages aren't written by multiple workers at once.
+func (srv *Server) writeListings() {
+	var scored []util.ScoredAPI
+	var owned []util.OwnedAPI
+	var deprecations []util.Deprecation
+	for _, api := range srv.indexed {
+		scored = append(scored, util.ScoredAPI{
+			Name:      api.endpoint.Name,
+			Page:      api.endpoint.PageName(),
+			Group:     api.endpoint.Cluster,
+			Namespace: api.endpoint.Namespace,
+			Score:     api.score,
+		})
+		owned = append(owned, util.OwnedAPI{
+			Name:      api.endpoint.Name,
+			Page:      api.endpoint.PageName(),
+			Group:     api.endpoint.Cluster,
+			Namespace: api.endpoint.Namespace,
+			Ownership: api.page.Ownership,
+		})
+		for _, deprecation := range api.page.Deprecations {
+			deprecation.API = api.endpoint.Name
+			deprecation.Page = api.endpoint.PageName()
+			deprecation.Group = api.endpoint.Cluster
+			deprecation.Namespace = api.endpoint.Namespace
+			deprecations = append(deprecations, deprecation)
+		}
+	}
+	if err := util.WriteSectionToDisk(util.ListingsDir, "Catalog", "{{% children %}}\n", srv.HugoStore); err != nil {
+		log.Printf("Error while writing the section of the listings: %s", err.Error())
+	}
+	if err := util.WriteLeaderboardToDisk(scored, srv.HugoStore); err != nil {
+		log.Printf("Error while writing leaderboard: %s", err.Error())
+	}
+	if err := util.WriteTeamsToDisk(owned, srv.HugoStore); err != nil {
+		log.Printf("Error while writing teams: %s", err.Error())
+	}
+	if err := util.WriteDeprecationsToDisk(deprecations, time.Now(), srv.HugoStore, srv.SwaggerStore); err != nil {
+		log.Printf("Error while writing deprecations: %s", err.Error())
+	}
+}
+
+// rewriteListings writes the pages that list the indexed APIs again and regenerates the site, so the sunsets that passed
+// since the listings were last written are no longer listed as upcoming
+func (srv *Server) rewriteListings() {
+	srv.mu.Lock()
+	srv.writeListings()
+	srv.mu.Unlock()
+	srv.generateDocs()
+}
+
+// rejectedError is the error of an OpenAPI document that isn't published because of its validation problems
+type rejectedError struct {
+	error
+}
+
+// retryable checks whether fetching failed because the service isn't fully started yet, in which case it should be
+// retried. Direct connections fail with a dial timeout, while the Kubernetes API server proxy reports that the
+// service has no endpoints.
+func retryable(err error) bool {
+	return strings.Contains(err.Error(), "dial tcp") || strings.Contains(err.Error(), "no endpoints available")
 }
 
-// remove deletes the service from the service map and removes the JSON and Markdown files from disk
-func remove(service *v1.Service, srv *Server) error {
-	log.Printf("Attempting to delete %s\n", service.Name)
+// fetch returns the OpenAPI document of the API endpoint and the notices to show for it, either through the source
+// that discovered it or from the SpecURL of the API endpoint. The files referenced by the document are bundled into
+// it. Fetching is cancelled after the FetchTimeout, or when the server stops running.
+func (srv *Server) fetch(endpoint discovery.Endpoint) (string, []string, error) {
+	ctx, cancel := context.WithTimeout(srv.context(), srv.FetchTimeout)
+	defer cancel()
 
-	// Remove JSON file
-	filename := filepath.Join(srv.SwaggerStore, fmt.Sprintf("%s.json", strings.Replace(strings.ToLower(service.Name), " ", "-", -1)))
-	err := os.Remove(filename)
+	var apidoc string
+	var notices []string
+	var err error
+	if fetcher, ok := srv.fetchers[endpoint.Source]; ok {
+		apidoc, notices, err = fetcher.Fetch(ctx, endpoint)
+	} else {
+		apidoc, err = util.GetAPIDoc(ctx, endpoint.SpecURL, nil, util.MaxAPIDocSize)
+	}
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	// Remove Markdown file
-	filename = filepath.Join(srv.HugoStore, fmt.Sprintf("%s.md", strings.Replace(strings.ToLower(service.Name), " ", "-", -1)))
-	err = os.Remove(filename)
+	// Resolve the external and relative references against the URL of the document
+	load := func(ctx context.Context, url string, maxSize int) (string, error) {
+		return util.GetAPIDoc(ctx, url, nil, maxSize)
+	}
+	if loader, ok := srv.loaders[endpoint.Source]; ok {
+		load = func(ctx context.Context, url string, maxSize int) (string, error) {
+			return loader.LoadRef(ctx, endpoint, url, maxSize)
+		}
+	}
+	apidoc, err = util.Bundle(ctx, apidoc, endpoint.SpecURL, load, srv.MaxRefFiles, srv.MaxRefSize, srv.MaxBundleSize, srv.RefHosts)
 	if err != nil {
+		return "", nil, err
+	}
+
+	return apidoc, notices, nil
+}
+
+// report sends the status of indexing the API endpoint back to the source that discovered it, when the source
+// is able to record it
+func (srv *Server) report(endpoint discovery.Endpoint, status discovery.Status) {
+	if reporter, ok := srv.reporters[endpoint.Source]; ok {
+		status.Time = time.Now()
+		reporter.ReportStatus(srv.context(), endpoint, status)
+	}
+}
+
+// remove deletes the API endpoint from the service map and removes the JSON and Markdown files from disk
+func remove(endpoint discovery.Endpoint, srv *Server) error {
+	log.Printf("Attempting to delete %s\n", endpoint.Name)
+
+	// Remove the JSON and Markdown files
+	if err := srv.unpublish(endpoint); err != nil {
 		return err
 	}
 
+	// Remove the full JSON file of an API with internal parts, which only exists when the API has them
+	if len(srv.InternalStore) > 0 {
+		os.Remove(util.InternalFilename(srv.InternalStore, endpoint.Cluster, endpoint.PageName()))
+	}
+
 	// Remove service from service map
-	delete(srv.ServiceMap, service.Name)
-	log.Printf("Service %s has been removed from API Scout\n", service.Name)
+	srv.mu.Lock()
+	delete(srv.ServiceMap, endpoint.Key())
+	delete(srv.indexed, endpoint.Key())
+	srv.writeListings()
+	srv.mu.Unlock()
+	log.Printf("Service %s has been removed from API Scout\n", endpoint.Name)
 
 	return nil
 }
+
+// unpublish removes the JSON and Markdown files of the API endpoint from the site. Files that don't exist are skipped,
+// like the files of an internal API, which are never published.
+func (srv *Server) unpublish(endpoint discovery.Endpoint) error {
+	name := strings.Replace(strings.ToLower(endpoint.PageName()), " ", "-", -1)
+	for _, filename := range []string{
+		filepath.Join(srv.SwaggerStore, endpoint.Cluster, fmt.Sprintf("%s.json", name)),
+		// The original JSON file of a converted API only exists when the API was converted
+		util.OriginalFilename(filepath.Join(srv.SwaggerStore, endpoint.Cluster), endpoint.PageName()),
+		filepath.Join(srv.HugoStore, endpoint.Cluster, fmt.Sprintf("%s.md", name)),
+	} {
+		if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// indexInternal publishes the document of an API that is declared internal to the restricted audience only, and
+// removes what was published for the API on the site before. The document isn't published at all when there is no
+// store for the restricted audience.
+func (srv *Server) indexInternal(endpoint discovery.Endpoint, apidoc string, host string, hash string, problems []string) (bool, error) {
+	if err := srv.unpublish(endpoint); err != nil {
+		srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+		return false, err
+	}
+
+	message := "The API is internal, so its OpenAPI document isn't published"
+	if len(srv.InternalStore) > 0 {
+		if err := util.WriteInternalToDisk(endpoint.PageName(), endpoint.Cluster, apidoc, host, srv.InternalStore); err != nil {
+			srv.report(endpoint, discovery.Status{Result: discovery.InvalidSpec, Message: err.Error()})
+			return false, err
+		}
+		message = fmt.Sprintf("The OpenAPI document was indexed from %s for the restricted audience only, as the API is internal", endpoint.SpecURL)
+	}
+
+	// The API isn't listed on the site, but is known so it isn't indexed again when it is added again
+	srv.mu.Lock()
+	srv.ServiceMap[endpoint.Key()] = "DONE"
+	if _, ok := srv.indexed[endpoint.Key()]; ok {
+		delete(srv.indexed, endpoint.Key())
+		srv.writeListings()
+	}
+	srv.mu.Unlock()
+
+	srv.report(endpoint, discovery.Status{
+		Result:   discovery.Indexed,
+		Message:  message,
+		Hash:     hash,
+		Problems: problems,
+	})
+	log.Printf("Internal service %s has been added to API Scout\n", endpoint.Name)
+
+	return true, nil
+}
@@ -1,16 +1,19 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
-	"k8s.io/apimachinery/pkg/watch"
-
-	"k8s.io/api/core/v1"
+	"github.com/TIBCOSoftware/apiscout/server/discovery"
+	"github.com/TIBCOSoftware/apiscout/server/util"
 )
 
 const swaggerJSONPayload = `{
@@ -91,45 +94,6 @@ const swaggerJSONPayload = `{
 	"basePath": "/"
 }`
 
-const kubeServicePayload = `{
-    "metadata": {
-        "name": "invoice-go-svc",
-        "namespace": "default",
-        "selfLink": "/api/v1/namespaces/default/services/invoice-go-svc",
-        "uid": "a3f33d97-e0cb-11e8-8617-c85b76f2707f",
-        "resourceVersion": "2982",
-        "creationTimestamp": "2018-11-05T07:23:06Z",
-        "labels": {
-            "run": "invoice-go-svc"
-        },
-        "annotations": {
-            "apiscout/index": "true",
-            "apiscout/swaggerUrl": "/swaggerspec",
-            "kubectl.kubernetes.io/last-applied-configuration": "{\"apiVersion\":\"v1\",\"kind\":\"Service\",\"metadata\":{\"annotations\":{\"apiscout/index\":\"true\",\"apiscout/swaggerUrl\":\"/swaggerspec\"},\"labels\":{\"run\":\"invoice-go-svc\"},\"name\":\"invoice-go-svc\",\"namespace\":\"default\"},\"spec\":{\"ports\":[{\"port\":80,\"protocol\":\"TCP\",\"targetPort\":8080}],\"selector\":{\"run\":\"invoice-go-svc\"},\"type\":\"LoadBalancer\"}}\n"
-        }
-    },
-    "spec": {
-        "ports": [
-            {
-                "protocol": "TCP",
-                "port": 80,
-                "targetPort": 8123,
-                "nodePort": 8123
-            }
-        ],
-        "selector": {
-            "run": "invoice-go-svc"
-        },
-        "clusterIP": "10.99.164.156",
-        "type": "LoadBalancer",
-        "sessionAffinity": "None",
-        "externalTrafficPolicy": "Cluster"
-    },
-    "status": {
-        "loadBalancer": {}
-    }
-}`
-
 func TestHandleService(t *testing.T) {
 	server := &http.Server{Addr: ":8123"}
 	http.HandleFunc("/swaggerspec", func(w http.ResponseWriter, r *http.Request) {
@@ -153,27 +117,48 @@ func TestHandleService(t *testing.T) {
 		<-done
 	}()
 
-	service := &v1.Service{}
-
-	json.Unmarshal([]byte(kubeServicePayload), service)
+	endpoint := discovery.Endpoint{
+		Name:    "invoice-go-svc",
+		Source:  "test",
+		SpecURL: "http://localhost:8123/swaggerspec",
+		Host:    "localhost:8123",
+	}
 
 	tempPath := "/tmp/apiscouttest1234"
-	runMode := "LOCAL"
-	externalIP := "localhost"
 
 	os.MkdirAll(tempPath, 0777)
 
-	srv, err := New(tempPath, tempPath, runMode, externalIP, tempPath)
+	srv, err := New(tempPath, tempPath, tempPath)
 	if err != nil {
 		panic(err.Error())
 	}
 
-	srv.handleService(service, watch.Added, 0)
-	if strings.Compare(srv.ServiceMap["invoice-go-svc"], "DONE") != 0 {
+	srv.handleService(endpoint, discovery.Added, 0)
+	if strings.Compare(srv.ServiceMap[endpoint.Key()], "DONE") != 0 {
 		t.Fatal("Service addition failed")
 	}
 
-	srv.handleService(service, watch.Deleted, 0)
+	// An API with the same name from another source gets a page of its own
+	other := endpoint
+	other.Source = "consul"
+	srv.handleService(other, discovery.Added, 0)
+	if len(srv.ServiceMap) != 2 {
+		t.Fatal("Service with the same name from another source replaced the first")
+	}
+	srv.handleService(other, discovery.Deleted, 0)
+	if _, err := os.Stat(filepath.Join(tempPath, "test_invoice-go-svc.md")); err != nil {
+		t.Fatal("Removing a service with the same name from another source removed the first")
+	}
+
+	// An unchanged document shouldn't be written again
+	page := filepath.Join(tempPath, "test_invoice-go-svc.md")
+	ioutil.WriteFile(page, []byte("unchanged"), 0644)
+	srv.handleService(endpoint, discovery.Modified, 0)
+	if content, _ := ioutil.ReadFile(page); string(content) != "unchanged" {
+		t.Fatal("Unchanged service was written again")
+	}
+
+	srv.handleService(endpoint, discovery.Deleted, 0)
 	if len(srv.ServiceMap) != 0 {
 		t.Fatal("Service removal failed")
 	}
@@ -181,3 +166,174 @@ func TestHandleService(t *testing.T) {
 	os.RemoveAll(tempPath)
 
 }
+
+// statusRecorder records the last status that was reported for an API endpoint
+type statusRecorder struct {
+	last discovery.Status
+}
+
+func (r *statusRecorder) ReportStatus(ctx context.Context, endpoint discovery.Endpoint, status discovery.Status) {
+	r.last = status
+}
+
+func TestHandleServiceFailure(t *testing.T) {
+	payload := swaggerJSONPayload
+	status := http.StatusOK
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, payload)
+	}))
+	defer service.Close()
+
+	dir, err := ioutil.TempDir("", "apiscout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	srv, _ := New(dir, dir, dir)
+	recorder := &statusRecorder{}
+	srv.reporters["test"] = recorder
+
+	endpoint := discovery.Endpoint{Name: "invoices", Source: "test", SpecURL: service.URL, Host: "localhost"}
+	page := filepath.Join(dir, endpoint.PageName()+".md")
+	srv.handleService(endpoint, discovery.Added, 0)
+	if !srv.isIndexed(endpoint) {
+		t.Fatal("Service addition failed")
+	}
+
+	// The document that was indexed before is kept when the service can't be fetched
+	status = http.StatusServiceUnavailable
+	srv.handleService(endpoint, discovery.Modified, 0)
+	if _, err := os.Stat(page); err != nil || !srv.isIndexed(endpoint) {
+		t.Fatal("Service was removed when it couldn't be fetched")
+	}
+	if recorder.last.Result != discovery.FetchFailed || !strings.Contains(recorder.last.Message, "is kept") {
+		t.Errorf("Unexpected status %+v", recorder.last)
+	}
+
+	// Invalid documents are kept as well, unless they are rejected
+	status = http.StatusOK
+	payload = `{"swagger": "2.0", "info": {"title": "Invoices"}, "paths": {"/invoices": {"get": {}}}}`
+	srv.handleService(endpoint, discovery.Modified, 0)
+	if !srv.isIndexed(endpoint) {
+		t.Fatal("Invalid service was removed without rejecting invalid documents")
+	}
+	srv.Validation = ValidationReject
+	payload = `{"swagger": "2.0", "info": {"title": "Invoices", "version": "1.0.0"}, "paths": {"/invoices": {"get": {}}}}`
+	srv.handleService(endpoint, discovery.Modified, 0)
+	if _, err := os.Stat(page); err == nil || srv.isIndexed(endpoint) {
+		t.Fatal("Rejected service wasn't removed")
+	}
+	if recorder.last.Result != discovery.InvalidSpec {
+		t.Errorf("Unexpected status %+v", recorder.last)
+	}
+}
+
+func TestRewriteListings(t *testing.T) {
+	store := t.TempDir()
+	srv, _ := New(store, store, store)
+
+	// The sunset is upcoming when the listings are written first, and has passed when they are written again
+	sunset := time.Now().Add(50 * time.Millisecond)
+	endpoint := discovery.Endpoint{Name: "invoices", Source: "test"}
+	srv.indexed = map[string]indexedAPI{endpoint.Key(): {
+		endpoint: endpoint,
+		page:     util.Page{Deprecations: []util.Deprecation{{Sunset: &sunset}}},
+	}}
+	srv.rewriteListings()
+	filename := filepath.Join(store, util.ListingsDir, "deprecations.md")
+	if content, _ := ioutil.ReadFile(filename); !strings.Contains(string(content), "## Upcoming sunsets") {
+		t.Fatalf("Expected an upcoming sunset, got:\n%s", content)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	srv.rewriteListings()
+	if content, _ := ioutil.ReadFile(filename); !strings.Contains(string(content), "## Passed sunsets") || strings.Contains(string(content), "## Upcoming sunsets") {
+		t.Errorf("Expected the sunset to have passed, got:\n%s", content)
+	}
+}
+
+func TestIndexRedactsHost(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"swagger": "2.0", "host": "localhost", "info": {"title": "Invoices", "version": "1.0.0"},
+			"paths": {"/invoices": {"get": {"responses": {"200": {"description": "OK"}}}}, "/admin": {"x-internal": true}}}`)
+	}))
+	defer service.Close()
+
+	store := t.TempDir()
+	rules := filepath.Join(store, "redaction.yml")
+	ioutil.WriteFile(rules, []byte("builtins: [ips]\n"), 0644)
+	srv, _ := New(store, store, store)
+	srv.InternalStore = filepath.Join(store, "internal")
+	if srv.Redaction, _ = util.LoadRedactionRules(rules); srv.Redaction == nil {
+		t.Fatal("Expected redaction rules")
+	}
+
+	// The host of the API is redacted from the documents that are written to disk, not only from the fetched document
+	endpoint := discovery.Endpoint{Name: "invoices", Source: "test", SpecURL: service.URL, Host: "10.96.0.12:8080"}
+	if _, err := index(endpoint, srv); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{
+		filepath.Join(store, endpoint.PageName()+".json"),
+		util.InternalFilename(srv.InternalStore, "", endpoint.PageName()),
+	} {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(content), "10.96.0.12") || !strings.Contains(string(content), `"host":"REDACTED:8080"`) {
+			t.Errorf("Expected the host to be redacted in %s, got %s", filename, content)
+		}
+	}
+}
+
+func TestIndexInternal(t *testing.T) {
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, swaggerJSONPayload)
+	}))
+	defer service.Close()
+
+	store := t.TempDir()
+	srv, _ := New(store, store, store)
+	srv.InternalStore = filepath.Join(store, "internal")
+	endpoint := discovery.Endpoint{Name: "invoices", Source: "test", SpecURL: service.URL, Host: "localhost"}
+	page := filepath.Join(store, endpoint.PageName()+".md")
+	internal := util.InternalFilename(srv.InternalStore, "", endpoint.PageName())
+	if _, err := index(endpoint, srv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(page); err != nil {
+		t.Fatal("Expected the public API to be published")
+	}
+
+	// An API that is declared internal is removed from the site and only written to the internal store
+	endpoint.Metadata = map[string]string{"apiscout/visibility": "internal"}
+	if _, err := index(endpoint, srv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(page); err == nil {
+		t.Error("Expected the page of the internal API to be removed")
+	}
+	if _, err := os.Stat(internal); err != nil {
+		t.Error("Expected the internal API in the internal store")
+	}
+	if content, _ := ioutil.ReadFile(filepath.Join(store, util.ListingsDir, "leaderboard.md")); strings.Contains(string(content), "invoices") {
+		t.Errorf("Expected the internal API not to be listed, got:\n%s", content)
+	}
+	if err := remove(endpoint, srv); err != nil || srv.isIndexed(endpoint) {
+		t.Fatalf("Expected the internal API to be removed, got %v", err)
+	}
+	if _, err := os.Stat(internal); err == nil {
+		t.Error("Expected the internal API to be removed from the internal store")
+	}
+
+	// Without an internal store, the internal API isn't written anywhere
+	srv.InternalStore = ""
+	if _, err := index(endpoint, srv); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(page); err == nil || !srv.isIndexed(endpoint) {
+		t.Error("Expected the internal API to be known, but not published")
+	}
+}
@@ -3,14 +3,20 @@ package util
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"text/template"
 )
 
@@ -18,40 +24,193 @@ import (
 const markdown = `---
 title: {{.title}}
 weight: 1000
+score: {{.total}}
+{{.frontmatter}}---
+
+{{.ownership}}{{.taxonomies}}{{.metadata}}{{.deprecations}}{{.notices}}{{.problems}}{{.score}}{{.lint}}{{.redactions}}{{.json}}`
+
+// A template for the Markdown file of a section in Hugo that groups APIs
+const sectionMarkdown = `---
+title: {{.title}}
+weight: 100
 ---
 
-{{.json}}`
+{{.body}}`
+
+// ListingsDir is the section of the Hugo store, and the directory of the store of the OpenAPI documents, in which the
+// pages that list the APIs (like the leaderboard) are written. It can't be used as a group, so the listings and the
+// pages of the APIs can't overwrite each other.
+const ListingsDir = "catalog"
 
-// GetAPIDoc performs an HTTP request to a specified URL to retrieve the OpenAPI document
-func GetAPIDoc(url string) (string, error) {
-	req, err := http.NewRequest("GET", url, nil)
+// cachedAPIDoc is an OpenAPI document that was retrieved before, together with the validators to retrieve it
+// conditionally
+type cachedAPIDoc struct {
+	etag         string
+	lastModified string
+	body         string
+}
+
+var (
+	// The OpenAPI documents that were retrieved before, by URL
+	apiDocCache = make(map[string]cachedAPIDoc)
+	// The mutex to guard the cache of OpenAPI documents
+	apiDocCacheMu sync.Mutex
+)
+
+// MaxAPIDocSize is the maximum size in bytes of an OpenAPI document that is fetched, so a service can't make apiscout
+// run out of memory
+const MaxAPIDocSize = 32 * 1024 * 1024
+
+// GetAPIDoc performs an HTTP request to a specified URL to retrieve the OpenAPI document, authenticating with the
+// credentials when they are not nil. A request with credentials doesn't follow redirects to another scheme or host,
+// so the credentials aren't sent anywhere else. The request is cancelled when the context is done. When the document
+// was retrieved before, the request is conditional (If-None-Match and If-Modified-Since) so the service doesn't have to
+// send a document that hasn't changed. Documents larger than maxSize bytes aren't read any further.
+func GetAPIDoc(ctx context.Context, url string, credentials *Credentials, maxSize int) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
+	credentials.Apply(req)
+
+	apiDocCacheMu.Lock()
+	cached, ok := apiDocCache[url]
+	apiDocCacheMu.Unlock()
+	if ok {
+		if len(cached.etag) > 0 {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if len(cached.lastModified) > 0 {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
 
-	res, err := http.DefaultClient.Do(req)
+	client := &http.Client{CheckRedirect: redirectPolicy(credentials)}
+	res, err := client.Do(req)
 	if err != nil {
 		return "", err
 	}
 
 	defer res.Body.Close()
 
-	body, err := ioutil.ReadAll(res.Body)
+	if res.StatusCode == http.StatusNotModified && ok {
+		return cached.body, nil
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return "", fmt.Errorf("unexpected response from %s: %s", url, res.Status)
+	}
+
+	body, err := ReadLimited(res.Body, url, maxSize)
 	if err != nil {
 		return "", err
 	}
 
+	// Remember the document when the service supports conditional requests
+	apiDocCacheMu.Lock()
+	if etag, lastModified := res.Header.Get("ETag"), res.Header.Get("Last-Modified"); len(etag) > 0 || len(lastModified) > 0 {
+		apiDocCache[url] = cachedAPIDoc{etag: etag, lastModified: lastModified, body: string(body)}
+	} else {
+		delete(apiDocCache, url)
+	}
+	apiDocCacheMu.Unlock()
+
 	return string(body), nil
 }
 
+// redirectCheckKey is the key of the context value with the check that every redirect followed by GetAPIDoc has to pass
+type redirectCheckKey struct{}
+
+// withRedirectCheck returns a context in which GetAPIDoc only follows redirects to the URLs that pass the check
+func withRedirectCheck(ctx context.Context, check func(string) error) context.Context {
+	return context.WithValue(ctx, redirectCheckKey{}, check)
+}
+
+// redirectPolicy returns the policy for the redirects of a request with the credentials. Go only removes some of the
+// headers with credentials when it follows a redirect to another host, so a request with credentials is never
+// redirected to another scheme or host at all.
+func redirectPolicy(credentials *Credentials) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("stopped after 10 redirects")
+		}
+		// A redirect has to pass the same check as the URL that was requested, like the hosts referenced files can be
+		// loaded from
+		if check, ok := req.Context().Value(redirectCheckKey{}).(func(string) error); ok {
+			if err := check(req.URL.String()); err != nil {
+				return err
+			}
+		}
+		if credentials != nil && (req.URL.Scheme != via[0].URL.Scheme || !strings.EqualFold(req.URL.Host, via[0].URL.Host)) {
+			return fmt.Errorf("refusing the redirect from %s to %s, as the credentials are only sent to %s", via[0].URL, req.URL, via[0].URL.Host)
+		}
+		return nil
+	}
+}
+
+// ReadLimited reads the document at url from r, returning an error as soon as it is larger than maxSize bytes
+func ReadLimited(r io.Reader, url string, maxSize int) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r, int64(maxSize)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxSize {
+		return nil, fmt.Errorf("the document at %s is larger than %d bytes", url, maxSize)
+	}
+	return body, nil
+}
+
+// Hash returns the SHA-256 hash of an OpenAPI document as a hex string. JSON documents are normalized first, so
+// differences in formatting or the order of keys don't change the hash.
+func Hash(apidoc string) string {
+	var doc interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err == nil {
+		if normalized, err := json.Marshal(doc); err == nil {
+			apidoc = string(normalized)
+		}
+	}
+	sum := sha256.Sum256([]byte(apidoc))
+	return hex.EncodeToString(sum[:])
+}
+
+// Page holds what is shown on the page of an API in addition to the OpenAPI document
+type Page struct {
+	// The title of the page when the OpenAPI document has no title, the name of the page is used when it is empty
+	Title string
+	// The warnings to show at the top of the page
+	Notices []string
+	// The specification the OpenAPI document was validated against
+	Spec string
+	// The problems that were found when validating the OpenAPI document
+	Problems []ValidationProblem
+	// Who to contact about the API
+	Ownership Ownership
+	// The tags, categories and labels by which the API can be browsed
+	Taxonomies Taxonomies
+	// The API itself and the operations of the API that are deprecated
+	Deprecations []Deprecation
+	// What is known about the deployment of the API, which is added to the OpenAPI document as well
+	Metadata *Metadata
+	// The results of linting the OpenAPI document
+	Lint []LintResult
+	// What was redacted from the OpenAPI document before it was published
+	Redactions []Redaction
+	// The original Swagger 2.0 document when the OpenAPI document was converted to OpenAPI 3.0, which is stored
+	// next to the converted document and linked from the page
+	Original string
+}
+
 // WriteSwaggerToDisk takes a swagger document and writes both its content as well as a hugo template to disk
-// to enable the static site to be updated with the new API
-func WriteSwaggerToDisk(name string, apidoc string, svchost string, swaggerStore string, hugoStore string) error {
+// to enable the static site to be updated with the new API. When a group is specified, the documents are
+// written in a subdirectory for that group so the API shows up in its own section of the site. The page
+// determines what is shown on the page of the API in addition to the document. The quality score of the API is
+// calculated from the document that is written and returned.
+func WriteSwaggerToDisk(name string, group string, apidoc string, svchost string, swaggerStore string, hugoStore string, page Page) (Score, error) {
 	// Unmarshal the string into a proper document
 	var swagger map[string]interface{}
 	if err := json.Unmarshal([]byte(apidoc), &swagger); err != nil {
 		log.Printf("error while unmarshaling JSON: %s", err.Error())
-		return fmt.Errorf("error while unmarshaling JSON: %s", err.Error())
+		return Score{}, fmt.Errorf("error while unmarshaling JSON: %s", err.Error())
 	}
 
 	// Update the host information
@@ -59,7 +218,17 @@ func WriteSwaggerToDisk(name string, apidoc string, svchost string, swaggerStore
 		swagger["host"] = svchost
 	}
 
+	// Add what is known about the deployment of the API
+	if page.Metadata != nil {
+		swagger[metadataExtension] = page.Metadata
+	}
+
 	// Determine where to save the file
+	swaggerStore = filepath.Join(swaggerStore, group)
+	if err := os.MkdirAll(swaggerStore, 0755); err != nil {
+		log.Printf("error while creating directory: %s", err.Error())
+		return Score{}, fmt.Errorf("error while creating directory: %s", err.Error())
+	}
 	filename := filepath.Join(swaggerStore, fmt.Sprintf("%s.json", strings.Replace(strings.ToLower(name), " ", "-", -1)))
 	log.Printf("Preparing to write %s to disk", filename)
 	os.Remove(filename)
@@ -68,7 +237,7 @@ func WriteSwaggerToDisk(name string, apidoc string, svchost string, swaggerStore
 	file, err := os.Create(filename)
 	if err != nil {
 		log.Printf("error while creating file: %s", err.Error())
-		return fmt.Errorf("error while creating file: %s", err.Error())
+		return Score{}, fmt.Errorf("error while creating file: %s", err.Error())
 	}
 	defer file.Close()
 
@@ -76,43 +245,75 @@ func WriteSwaggerToDisk(name string, apidoc string, svchost string, swaggerStore
 	file, err = os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		log.Printf("error while opening file: %s", err.Error())
-		return fmt.Errorf("error while opening file: %s", err.Error())
+		return Score{}, fmt.Errorf("error while opening file: %s", err.Error())
 	}
 
 	// Serialize the OpenAPI doc
 	apibytes, err := json.Marshal(swagger)
 	if err != nil {
 		log.Printf("error while marshaling API: %s", err.Error())
-		return fmt.Errorf("error while marshaling API: %s", err.Error())
+		return Score{}, fmt.Errorf("error while marshaling API: %s", err.Error())
 	}
 
 	// Write the OpenAPI doc to disk
 	_, err = file.Write(apibytes)
 	if err != nil {
 		log.Printf("error while writing OpenAPI to disk: %s", err.Error())
-		return fmt.Errorf("error while writing OpenAPI to disk: %s", err.Error())
+		return Score{}, fmt.Errorf("error while writing OpenAPI to disk: %s", err.Error())
 	}
 
-	// Prepare the Markdown file for Hugo
-	var title string
-	if val, ok := swagger["info"].(map[string]interface{})["title"]; ok {
-		title = val.(string)
+	// Write the original document to disk when the document was converted
+	if len(page.Original) > 0 {
+		if err := writeOriginal(page.Original, svchost, OriginalFilename(swaggerStore, name)); err != nil {
+			log.Printf("error while writing original OpenAPI to disk: %s", err.Error())
+			return Score{}, fmt.Errorf("error while writing original OpenAPI to disk: %s", err.Error())
+		}
+	}
+
+	// Prepare the Markdown file for Hugo, using the title of the page or the name when the document has no title
+	title := page.Title
+	if len(title) == 0 {
+		title = name
+	}
+	if info, ok := swagger["info"].(map[string]interface{}); ok {
+		if val, ok := info["title"].(string); ok && len(val) > 0 {
+			title = val
+		}
 	}
 
+	// Score the quality of the API
+	score := ScoreAPI(apidoc, page.Lint)
+
 	dataMap := make(map[string]interface{})
 	dataMap["title"] = title
-	dataMap["json"] = fmt.Sprintf("{{< oas3 url=\"../../../swaggerdocs/%s.json\" >}}", strings.Replace(strings.ToLower(name), " ", "-", -1))
+	dataMap["total"] = score.Total
+	dataMap["score"] = scoreMarkdown(score)
+	dataMap["frontmatter"] = page.Ownership.frontMatter() + page.Taxonomies.frontMatter()
+	dataMap["ownership"] = ownershipMarkdown(page.Ownership, group)
+	dataMap["taxonomies"] = taxonomiesMarkdown(page.Taxonomies, group)
+	dataMap["metadata"] = metadataMarkdown(page.Metadata)
+	dataMap["deprecations"] = deprecationsNotice(page.Deprecations)
+	dataMap["notices"] = noticesMarkdown(page.Notices)
+	dataMap["problems"] = problemsMarkdown(page.Spec, page.Problems) + originalMarkdown(name, group, page.Original)
+	dataMap["lint"] = lintMarkdown(page.Lint)
+	dataMap["redactions"] = redactionsMarkdown(page.Redactions)
+	dataMap["json"] = fmt.Sprintf("{{< oas3 url=\"%sswaggerdocs/%s.json\" >}}", relativeRoot(group), path.Join(group, strings.Replace(strings.ToLower(name), " ", "-", -1)))
 
 	// Render the Markdown file based on the template
 	t := template.Must(template.New("top").Parse(markdown))
 	buf := &bytes.Buffer{}
 	if err := t.Execute(buf, dataMap); err != nil {
 		log.Printf("error while rendering Markdown file: %s", err.Error())
-		return fmt.Errorf("error while rendering Markdown file: %s", err.Error())
+		return Score{}, fmt.Errorf("error while rendering Markdown file: %s", err.Error())
 	}
 	s := buf.String()
 
 	// Determine where to save the file
+	hugoStore = filepath.Join(hugoStore, group)
+	if err := os.MkdirAll(hugoStore, 0755); err != nil {
+		log.Printf("error while creating directory: %s", err.Error())
+		return Score{}, fmt.Errorf("error while creating directory: %s", err.Error())
+	}
 	filename = filepath.Join(hugoStore, fmt.Sprintf("%s.md", strings.Replace(strings.ToLower(name), " ", "-", -1)))
 	log.Printf("Preparing to write %s to disk", filename)
 	os.Remove(filename)
@@ -121,7 +322,7 @@ func WriteSwaggerToDisk(name string, apidoc string, svchost string, swaggerStore
 	file, err = os.Create(filename)
 	if err != nil {
 		log.Printf("error while creating file: %s", err.Error())
-		return fmt.Errorf("error while creating file: %s", err.Error())
+		return Score{}, fmt.Errorf("error while creating file: %s", err.Error())
 	}
 	defer file.Close()
 
@@ -129,15 +330,157 @@ func WriteSwaggerToDisk(name string, apidoc string, svchost string, swaggerStore
 	file, err = os.OpenFile(filename, os.O_APPEND|os.O_WRONLY, 0600)
 	if err != nil {
 		log.Printf("error while opening file: %s", err.Error())
-		return fmt.Errorf("error while opening file: %s", err.Error())
+		return Score{}, fmt.Errorf("error while opening file: %s", err.Error())
 	}
 
 	// Write the Markdown doc to disk
 	_, err = file.Write([]byte(s))
 	if err != nil {
 		log.Printf("error while writing Markdown to disk: %s", err.Error())
-		return fmt.Errorf("error while writing Markdown to disk: %s", err.Error())
+		return Score{}, fmt.Errorf("error while writing Markdown to disk: %s", err.Error())
+	}
+
+	return score, nil
+}
+
+// WriteSectionToDisk writes the Markdown file for a section in Hugo that groups the APIs of a group (like a cluster)
+func WriteSectionToDisk(group string, title string, body string, hugoStore string) error {
+	dataMap := make(map[string]interface{})
+	dataMap["title"] = title
+	dataMap["body"] = body
+	return writeMarkdown(sectionMarkdown, dataMap, filepath.Join(hugoStore, group), "_index.md")
+}
+
+// writeMarkdown renders a Markdown file based on the template and writes it to the file in the directory, which is
+// created when it doesn't exist yet
+func writeMarkdown(markdown string, dataMap map[string]interface{}, dir string, filename string) error {
+	// Render the Markdown file based on the template
+	t := template.Must(template.New("top").Parse(markdown))
+	buf := &bytes.Buffer{}
+	if err := t.Execute(buf, dataMap); err != nil {
+		log.Printf("error while rendering Markdown file: %s", err.Error())
+		return fmt.Errorf("error while rendering Markdown file: %s", err.Error())
 	}
 
+	// Determine where to save the file
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("error while creating directory: %s", err.Error())
+		return fmt.Errorf("error while creating directory: %s", err.Error())
+	}
+
+	// Write the Markdown doc to disk
+	if err := ioutil.WriteFile(filepath.Join(dir, filename), buf.Bytes(), 0644); err != nil {
+		log.Printf("error while writing Markdown to disk: %s", err.Error())
+		return fmt.Errorf("error while writing Markdown to disk: %s", err.Error())
+	}
 	return nil
 }
+
+// noticesMarkdown renders the notices as warnings using the notice shortcode of the theme
+func noticesMarkdown(notices []string) string {
+	var buf bytes.Buffer
+	for _, notice := range notices {
+		buf.WriteString(fmt.Sprintf("{{%% notice warning %%}}\n%s\n{{%% /notice %%}}\n\n", notice))
+	}
+	return buf.String()
+}
+
+// problemsMarkdown renders the validation problems as a panel using the notice shortcode of the theme
+func problemsMarkdown(spec string, problems []ValidationProblem) string {
+	if len(problems) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("{{% notice warning %}}\n**Validation problems**\n\n")
+	if len(spec) > 0 {
+		buf.WriteString(fmt.Sprintf("This OpenAPI document doesn't conform to the %s specification:\n\n", spec))
+	} else {
+		buf.WriteString("This OpenAPI document doesn't conform to any supported specification:\n\n")
+	}
+	for _, problem := range problems {
+		if len(problem.Location) > 0 {
+			buf.WriteString(fmt.Sprintf("* `%s`: %s\n", problem.Location, problem.Message))
+		} else {
+			buf.WriteString(fmt.Sprintf("* %s\n", problem.Message))
+		}
+	}
+	buf.WriteString("{{% /notice %}}\n\n")
+	return buf.String()
+}
+
+// lintMarkdown renders the lint results as a collapsed table using the expand shortcode of the theme
+func lintMarkdown(results []LintResult) string {
+	if len(results) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("{{%% expand \"Lint report (%d findings)\" %%}}\n", len(results)))
+	buf.WriteString("| Severity | Rule | Location | Message |\n|---|---|---|---|\n")
+	for _, result := range results {
+		buf.WriteString(fmt.Sprintf("| %s | %s | `%s` | %s |\n", result.Severity, result.Rule, result.Location, strings.Replace(result.Message, "|", "\\|", -1)))
+	}
+	buf.WriteString("{{% /expand %}}\n\n")
+	return buf.String()
+}
+
+// redactionsMarkdown renders what was redacted as a collapsed table using the expand shortcode of the theme, without
+// the values that were redacted
+func redactionsMarkdown(redactions []Redaction) string {
+	if len(redactions) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("{{%% expand \"Redaction report (%d redactions)\" %%}}\n", len(redactions)))
+	buf.WriteString("| Rule | Location |\n|---|---|\n")
+	for _, redaction := range redactions {
+		buf.WriteString(fmt.Sprintf("| %s | `%s` |\n", redaction.Rule, redaction.Location))
+	}
+	buf.WriteString("{{% /expand %}}\n\n")
+	return buf.String()
+}
+
+// OriginalFilename returns the name of the file in the directory in which the original document of a converted API is
+// stored
+func OriginalFilename(dir string, name string) string {
+	return filepath.Join(dir, fmt.Sprintf("%s.original.json", strings.Replace(strings.ToLower(name), " ", "-", -1)))
+}
+
+// writeOriginal writes the original document of a converted API to disk, with the host updated like the converted
+// document
+func writeOriginal(original string, svchost string, filename string) error {
+	var swagger map[string]interface{}
+	if err := json.Unmarshal([]byte(original), &swagger); err != nil {
+		return err
+	}
+	if _, ok := swagger["host"]; ok {
+		swagger["host"] = svchost
+	}
+
+	apibytes, err := json.Marshal(swagger)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filename, apibytes, 0644)
+}
+
+// originalMarkdown renders a link to the original document of a converted API
+func originalMarkdown(name string, group string, original string) string {
+	if len(original) == 0 {
+		return ""
+	}
+	url := fmt.Sprintf("%sswaggerdocs/%s.original.json", relativeRoot(group), path.Join(group, strings.Replace(strings.ToLower(name), " ", "-", -1)))
+	return fmt.Sprintf("{{%% notice info %%}}\nThis API was converted from Swagger 2.0 to OpenAPI 3.0, the original document is available [here](%s).\n{{%% /notice %%}}\n\n", url)
+}
+
+// relativeRoot returns the relative path from the page of an API to the root of the site, taking into account
+// that every group adds a level to the page
+func relativeRoot(group string) string {
+	depth := 3
+	if len(group) > 0 {
+		depth += len(strings.Split(group, "/"))
+	}
+	return strings.Repeat("../", depth)
+}
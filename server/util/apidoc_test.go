@@ -0,0 +1,127 @@
+package util
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetAPIDocConditional(t *testing.T) {
+	served := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		served++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(`{"swagger": "2.0"}`))
+	}))
+	defer server.Close()
+
+	for i := 0; i < 2; i++ {
+		apidoc, err := GetAPIDoc(context.Background(), server.URL, nil, 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if apidoc != `{"swagger": "2.0"}` {
+			t.Fatalf("Unexpected document %s", apidoc)
+		}
+	}
+	if served != 1 {
+		t.Fatalf("Expected the document to be served once, got %d", served)
+	}
+}
+
+func TestGetAPIDocTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"swagger": "2.0"}`))
+	}))
+	defer server.Close()
+
+	if _, err := GetAPIDoc(context.Background(), server.URL, nil, 18); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := GetAPIDoc(context.Background(), server.URL, nil, 17); err == nil || !strings.Contains(err.Error(), "larger than 17 bytes") {
+		t.Fatalf("Expected an error for a document that is too large, got %v", err)
+	}
+}
+
+func TestGetAPIDocRedirect(t *testing.T) {
+	var leaked []string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		leaked = append(leaked, r.Header.Get("X-API-Key"))
+		w.Write([]byte(`{"swagger": "2.0"}`))
+	}))
+	defer other.Close()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/moved":
+			http.Redirect(w, r, "/openapi.json", http.StatusFound)
+		case "/openapi.json":
+			w.Write([]byte(`{"openapi": "3.0.0"}`))
+		default:
+			http.Redirect(w, r, other.URL+"/openapi.json", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+	credentials := &Credentials{Header: "X-API-Key", Value: "s3cr3t"}
+
+	// Redirects on the same host are followed with the credentials
+	if apidoc, err := GetAPIDoc(context.Background(), server.URL+"/moved", credentials, 1024); err != nil || apidoc != `{"openapi": "3.0.0"}` {
+		t.Fatalf("Unexpected document %s and error %v", apidoc, err)
+	}
+
+	// The credentials aren't sent to another host, while requests without credentials can be redirected there
+	if _, err := GetAPIDoc(context.Background(), server.URL+"/elsewhere", credentials, 1024); err == nil || !strings.Contains(err.Error(), "refusing the redirect") {
+		t.Errorf("Expected an error for the redirect to another host, got %v", err)
+	}
+	if len(leaked) != 0 {
+		t.Fatalf("Expected the other host not to be requested, got %v", leaked)
+	}
+	if _, err := GetAPIDoc(context.Background(), server.URL+"/elsewhere", nil, 1024); err != nil || len(leaked) != 1 || len(leaked[0]) > 0 {
+		t.Errorf("Expected the redirect without credentials to be followed, got %v and %v", err, leaked)
+	}
+}
+
+func TestHash(t *testing.T) {
+	if Hash(`{"swagger": "2.0", "info": {}}`) != Hash(`{"info":{},"swagger":"2.0"}`) {
+		t.Fatal("Expected the same hash for documents that only differ in formatting")
+	}
+}
+
+func TestRelativeRoot(t *testing.T) {
+	// Pages are in /<language>/apis/<group>/<name>/, so every level of the group adds a level
+	for group, expected := range map[string]string{"": "../../../", "prod": "../../../../", "eu/prod": "../../../../../"} {
+		if root := relativeRoot(group); root != expected {
+			t.Errorf("Expected %s for group %q, got %s", expected, group, root)
+		}
+		if root := storeRoot(group); root != strings.TrimPrefix(expected, "../../") {
+			t.Errorf("Expected %s for group %q, got %s", strings.TrimPrefix(expected, "../../"), group, root)
+		}
+	}
+}
+
+func TestWriteSectionToDisk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apiscout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := WriteSectionToDisk("prod", "Production", "Healthy", dir); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "prod", "_index.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "---\ntitle: Production\nweight: 100\n---\n\nHealthy"; string(content) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(content))
+	}
+}
@@ -0,0 +1,37 @@
+// Package util implements utility methods
+package util
+
+import (
+	"net/http"
+)
+
+// Credentials are used to authenticate to a service when retrieving its OpenAPI document. They are only ever
+// added to requests, never logged or written to disk.
+type Credentials struct {
+	// The bearer token to send in the Authorization header
+	Token string
+	// The username for basic authentication
+	Username string
+	// The password for basic authentication
+	Password string
+	// The name of the header to send an API key in
+	Header string
+	// The API key to send in the header
+	Value string
+}
+
+// Apply adds the credentials to the request
+func (c *Credentials) Apply(req *http.Request) {
+	if c == nil {
+		return
+	}
+	if len(c.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if len(c.Username) > 0 {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	if len(c.Header) > 0 {
+		req.Header.Set(c.Header, c.Value)
+	}
+}
@@ -0,0 +1,361 @@
+// Package util implements utility methods
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Loader loads the file at an absolute URL that is referenced by an OpenAPI document, reading at most maxSize bytes
+type Loader func(ctx context.Context, url string, maxSize int) (string, error)
+
+// bundler resolves the external references of a single OpenAPI document
+type bundler struct {
+	ctx  context.Context
+	load Loader
+	// The maximum number of files that can be referenced
+	maxFiles int
+	// The maximum size of a referenced file in bytes
+	maxSize int
+	// The maximum size in bytes of the content that is added to the document for its references
+	maxTotalSize int
+	// The size in bytes of the content that has been added to the document so far
+	totalSize int
+	// The size in bytes of the content of the references that have been resolved, by reference
+	sizes map[string]int
+	// The scheme and host of the document, from which files can always be loaded
+	origin *url.URL
+	// The other hosts from which files can be loaded
+	hosts map[string]bool
+	// The referenced files that have been loaded, by URL
+	files map[string]interface{}
+	// The references that are being resolved, to detect cycles
+	resolving map[string]bool
+	// The names of the schemas in the document for references that are part of a cycle, by reference
+	names map[string]string
+	// The schemas to add to the document for references that are part of a cycle, by name
+	hoisted map[string]interface{}
+	// The existing schemas of the document, by name
+	schemas map[string]interface{}
+	// The prefix of internal references to the schemas of the document
+	prefix string
+	// Whether the document has any external references
+	external bool
+}
+
+// Bundle resolves the external and relative references ($ref) of the OpenAPI document against baseURL and returns a
+// self-contained document. Referenced files can be JSON or YAML and are loaded with load. The content of a reference
+// replaces the reference, except for references that are part of a cycle, which are added to the schemas of the
+// document (definitions for Swagger 2.0 and components/schemas for OpenAPI 3) and referenced from there. At most
+// maxFiles files of at most maxSize bytes each are loaded, and the content that replaces the references can't add up
+// to more than maxTotalSize bytes, as content that is referenced more than once is copied every time. Files are only
+// loaded from the scheme and host of baseURL and from the hosts (also when they are redirected), so a document can't
+// make apiscout request (and publish) anything else it can reach. A document without external references, or that isn't a JSON object at all,
+// is returned as is.
+func Bundle(ctx context.Context, apidoc string, baseURL string, load Loader, maxFiles int, maxSize int, maxTotalSize int, hosts []string) (string, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return apidoc, nil
+	}
+
+	origin, err := url.Parse(baseURL)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing %s: %s", baseURL, err.Error())
+	}
+	b := &bundler{
+		ctx:          ctx,
+		load:         load,
+		maxFiles:     maxFiles,
+		maxSize:      maxSize,
+		maxTotalSize: maxTotalSize,
+		sizes:        make(map[string]int),
+		origin:       origin,
+		hosts:        make(map[string]bool),
+		files:        make(map[string]interface{}),
+		resolving:    make(map[string]bool),
+		names:        make(map[string]string),
+		hoisted:      make(map[string]interface{}),
+	}
+	for _, host := range hosts {
+		b.hosts[strings.ToLower(host)] = true
+	}
+
+	// Determine where the schemas of the document are
+	if _, ok := doc["swagger"]; ok {
+		b.schemas, _ = doc["definitions"].(map[string]interface{})
+		b.prefix = "#/definitions/"
+	} else {
+		components, _ := doc["components"].(map[string]interface{})
+		b.schemas, _ = components["schemas"].(map[string]interface{})
+		b.prefix = "#/components/schemas/"
+	}
+
+	resolved, err := b.resolve(doc, baseURL, true)
+	if err != nil {
+		return "", err
+	}
+	if !b.external {
+		return apidoc, nil
+	}
+	doc = resolved.(map[string]interface{})
+
+	// Add the schemas of the references that are part of a cycle
+	if len(b.hoisted) > 0 {
+		if b.schemas == nil {
+			b.schemas = make(map[string]interface{})
+		}
+		for name, schema := range b.hoisted {
+			b.schemas[name] = schema
+		}
+		if b.prefix == "#/definitions/" {
+			doc["definitions"] = b.schemas
+		} else {
+			components, ok := doc["components"].(map[string]interface{})
+			if !ok {
+				components = make(map[string]interface{})
+				doc["components"] = components
+			}
+			components["schemas"] = b.schemas
+		}
+	}
+
+	apibytes, err := json.Marshal(doc)
+	if err != nil {
+		return "", fmt.Errorf("error while marshaling API: %s", err.Error())
+	}
+	return string(apibytes), nil
+}
+
+// resolve replaces the external references in the node, which is part of the file at base. Local references (#/...)
+// are kept in the root document, but refer to the referenced file anywhere else.
+func (b *bundler) resolve(node interface{}, base string, root bool) (interface{}, error) {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := value["$ref"].(string); ok {
+			if root && strings.HasPrefix(ref, "#") {
+				return value, nil
+			}
+			target, err := resolveURL(base, ref)
+			if err != nil {
+				return nil, err
+			}
+			if err := b.allowed(target); err != nil {
+				return nil, err
+			}
+			b.external = true
+			return b.resolveRef(target)
+		}
+		for key, child := range value {
+			resolved, err := b.resolve(child, base, root)
+			if err != nil {
+				return nil, err
+			}
+			value[key] = resolved
+		}
+		return value, nil
+	case []interface{}:
+		for i, child := range value {
+			resolved, err := b.resolve(child, base, root)
+			if err != nil {
+				return nil, err
+			}
+			value[i] = resolved
+		}
+		return value, nil
+	default:
+		return node, nil
+	}
+}
+
+// resolveRef returns the content of the reference to target, which is an absolute URL with an optional fragment
+func (b *bundler) resolveRef(target string) (interface{}, error) {
+	// References that are part of a cycle refer to the schema that was added for them
+	if name, ok := b.names[target]; ok {
+		return map[string]interface{}{"$ref": b.prefix + name}, nil
+	}
+	if b.resolving[target] {
+		name := b.name(target)
+		b.names[target] = name
+		return map[string]interface{}{"$ref": b.prefix + name}, nil
+	}
+
+	file, fragment := target, ""
+	if i := strings.Index(target, "#"); i >= 0 {
+		file, fragment = target[:i], target[i+1:]
+	}
+	doc, err := b.file(file)
+	if err != nil {
+		return nil, err
+	}
+	node, err := pointer(doc, fragment)
+	if err != nil {
+		return nil, fmt.Errorf("error while resolving %s: %s", target, err.Error())
+	}
+
+	// Count the content that is copied into the document, its own references are counted when they are resolved
+	size, ok := b.sizes[target]
+	if !ok {
+		data, _ := json.Marshal(node)
+		size = len(data)
+		b.sizes[target] = size
+	}
+	b.totalSize += size
+	if b.totalSize > b.maxTotalSize {
+		return nil, fmt.Errorf("the references of the OpenAPI document add more than %d bytes", b.maxTotalSize)
+	}
+
+	// Resolve a copy, as the same content can be referenced more than once
+	b.resolving[target] = true
+	resolved, err := b.resolve(deepCopy(node), file, false)
+	delete(b.resolving, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if name, ok := b.names[target]; ok {
+		b.hoisted[name] = resolved
+		return map[string]interface{}{"$ref": b.prefix + name}, nil
+	}
+	return resolved, nil
+}
+
+// file loads and parses the referenced file, enforcing the limits on the number and size of referenced files
+func (b *bundler) file(fileURL string) (interface{}, error) {
+	if doc, ok := b.files[fileURL]; ok {
+		return doc, nil
+	}
+	if len(b.files) >= b.maxFiles {
+		return nil, fmt.Errorf("the OpenAPI document references more than %d files", b.maxFiles)
+	}
+
+	// The file can redirect to another host, which has to be allowed as well
+	content, err := b.load(withRedirectCheck(b.ctx, b.allowed), fileURL, b.maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("error while loading referenced file %s: %s", fileURL, err.Error())
+	}
+	if len(content) > b.maxSize {
+		return nil, fmt.Errorf("the referenced file %s is larger than %d bytes", fileURL, b.maxSize)
+	}
+
+	// YAML is a superset of JSON, so both can be parsed the same way
+	data, err := yaml.YAMLToJSON([]byte(content))
+	if err != nil {
+		return nil, fmt.Errorf("error while parsing referenced file %s: %s", fileURL, err.Error())
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("error while parsing referenced file %s: %s", fileURL, err.Error())
+	}
+
+	b.files[fileURL] = doc
+	return doc, nil
+}
+
+// allowed checks whether the referenced file at target can be loaded, which is when it has the same scheme and host
+// as the document or is on one of the other hosts
+func (b *bundler) allowed(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("error while parsing reference %s: %s", target, err.Error())
+	}
+	if u.Scheme == b.origin.Scheme && strings.EqualFold(u.Host, b.origin.Host) {
+		return nil
+	}
+	if (u.Scheme == "http" || u.Scheme == "https") && b.hosts[strings.ToLower(u.Host)] {
+		return nil
+	}
+	return fmt.Errorf("the reference to %s isn't on the host of the OpenAPI document", target)
+}
+
+// name returns a unique name for the schema of a reference, based on the last part of the fragment or the file name
+func (b *bundler) name(target string) string {
+	var base string
+	if i := strings.Index(target, "#"); i >= 0 && len(strings.Trim(target[i+1:], "/")) > 0 {
+		base = path.Base(target[i+1:])
+	} else {
+		base = path.Base(strings.TrimSuffix(target, "#"))
+		base = strings.TrimSuffix(base, path.Ext(base))
+	}
+
+	name := base
+	for i := 2; ; i++ {
+		_, existing := b.schemas[name]
+		_, hoisted := b.hoisted[name]
+		taken := false
+		for _, n := range b.names {
+			taken = taken || n == name
+		}
+		if !existing && !hoisted && !taken {
+			return name
+		}
+		name = base + strconv.Itoa(i)
+	}
+}
+
+// resolveURL resolves a reference against the URL of the file it appears in
+func resolveURL(base string, ref string) (string, error) {
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing %s: %s", base, err.Error())
+	}
+	refURL, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing reference %s: %s", ref, err.Error())
+	}
+	return baseURL.ResolveReference(refURL).String(), nil
+}
+
+// pointer returns the node in the document the JSON pointer refers to
+func pointer(doc interface{}, fragment string) (interface{}, error) {
+	node := doc
+	for _, token := range strings.Split(strings.Trim(fragment, "/"), "/") {
+		if len(token) == 0 {
+			continue
+		}
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		switch value := node.(type) {
+		case map[string]interface{}:
+			child, ok := value[token]
+			if !ok {
+				return nil, fmt.Errorf("%s doesn't exist", token)
+			}
+			node = child
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(value) {
+				return nil, fmt.Errorf("%s doesn't exist", token)
+			}
+			node = value[i]
+		default:
+			return nil, fmt.Errorf("%s doesn't exist", token)
+		}
+	}
+	return node, nil
+}
+
+// deepCopy returns a copy of a parsed JSON node
+func deepCopy(node interface{}) interface{} {
+	switch value := node.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, child := range value {
+			copied[key] = deepCopy(child)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, child := range value {
+			copied[i] = deepCopy(child)
+		}
+		return copied
+	default:
+		return node
+	}
+}
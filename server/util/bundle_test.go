@@ -0,0 +1,170 @@
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestBundle(t *testing.T) {
+	files := map[string]string{
+		"/schemas/invoice.yaml": `
+Invoice:
+  type: object
+  properties:
+    customer:
+      $ref: './customer.yaml'
+    related:
+      type: array
+      items:
+        $ref: '#/Invoice'
+`,
+		"/schemas/customer.yaml": `
+type: object
+properties:
+  name:
+    type: string
+`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		content, ok := files[r.URL.Path]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte(content))
+	}))
+	defer server.Close()
+
+	load := func(ctx context.Context, url string, maxSize int) (string, error) {
+		return GetAPIDoc(ctx, url, nil, maxSize)
+	}
+	openapi := `{"openapi": "3.0.3", "paths": {"/invoices": {"get": {"responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "./schemas/invoice.yaml#/Invoice"}}}}}}}}}`
+
+	apidoc, err := Bundle(context.Background(), openapi, server.URL+"/openapi.json", load, 10, 1024, 4096, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The cyclic Invoice schema is added to the components, the customer schema is inlined
+	var doc struct {
+		Components struct {
+			Schemas map[string]struct {
+				Properties map[string]struct {
+					Type  string
+					Items map[string]string
+				}
+			}
+		}
+		Paths map[string]interface{}
+	}
+	json.Unmarshal([]byte(apidoc), &doc)
+	invoice, ok := doc.Components.Schemas["Invoice"]
+	if !ok {
+		t.Fatalf("Expected the Invoice schema in the components, got %s", apidoc)
+	}
+	if invoice.Properties["customer"].Type != "object" {
+		t.Errorf("Expected the customer schema to be inlined, got %s", apidoc)
+	}
+	if invoice.Properties["related"].Items["$ref"] != "#/components/schemas/Invoice" {
+		t.Errorf("Expected the cycle to refer to the Invoice schema, got %s", apidoc)
+	}
+	if !strings.Contains(apidoc, `"$ref":"#/components/schemas/Invoice"`) {
+		t.Errorf("Expected the operation to refer to the Invoice schema, got %s", apidoc)
+	}
+
+	// The number of referenced files is capped
+	if _, err := Bundle(context.Background(), openapi, server.URL+"/openapi.json", load, 1, 1024, 4096, nil); err == nil {
+		t.Error("Expected an error when referencing too many files")
+	}
+
+	// The size of the content that replaces the references is capped
+	if _, err := Bundle(context.Background(), openapi, server.URL+"/openapi.json", load, 10, 1024, 64, nil); err == nil {
+		t.Error("Expected an error when the references add too much content")
+	}
+
+	// Documents without external references are returned as is
+	if apidoc, _ := Bundle(context.Background(), `{"swagger": "2.0"}`, server.URL, load, 10, 1024, 4096, nil); apidoc != `{"swagger": "2.0"}` {
+		t.Errorf("Unexpected document %s", apidoc)
+	}
+}
+
+func TestBundleHosts(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"type": "string"}`))
+	}))
+	defer other.Close()
+	otherURL, _ := url.Parse(other.URL)
+
+	var loaded []string
+	load := func(ctx context.Context, url string, maxSize int) (string, error) {
+		loaded = append(loaded, url)
+		return GetAPIDoc(ctx, url, nil, maxSize)
+	}
+
+	// References to other hosts, like the metadata endpoint of a cloud provider, aren't followed
+	for _, ref := range []string{other.URL + "/name.json", "http://169.254.169.254/latest/meta-data/", "file:///etc/passwd"} {
+		openapi := `{"openapi": "3.0.3", "components": {"schemas": {"Name": {"$ref": "` + ref + `"}}}}`
+		if _, err := Bundle(context.Background(), openapi, "http://invoices.billing/openapi.json", load, 10, 1024, 4096, nil); err == nil || !strings.Contains(err.Error(), "isn't on the host") {
+			t.Errorf("Expected an error for the reference to %s, got %v", ref, err)
+		}
+	}
+	if len(loaded) != 0 {
+		t.Fatalf("Expected nothing to be loaded, got %v", loaded)
+	}
+
+	// Unless the host is allowed
+	openapi := `{"openapi": "3.0.3", "components": {"schemas": {"Name": {"$ref": "` + other.URL + `/name.json"}}}}`
+	apidoc, err := Bundle(context.Background(), openapi, "http://invoices.billing/openapi.json", load, 10, 1024, 4096, []string{otherURL.Host})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(apidoc, `"Name":{"type":"string"}`) {
+		t.Errorf("Expected the schema to be inlined, got %s", apidoc)
+	}
+
+	// An allowed host can't redirect to a host that isn't allowed
+	requested := false
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+		w.Write([]byte(`{"type": "string", "description": "internal"}`))
+	}))
+	defer internal.Close()
+	redirecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internal.URL+"/name.json", http.StatusFound)
+	}))
+	defer redirecting.Close()
+	redirectingURL, _ := url.Parse(redirecting.URL)
+	openapi = `{"openapi": "3.0.3", "components": {"schemas": {"Name": {"$ref": "` + redirecting.URL + `/name.json"}}}}`
+	if _, err := Bundle(context.Background(), openapi, "http://invoices.billing/openapi.json", load, 10, 1024, 4096, []string{redirectingURL.Host}); err == nil || !strings.Contains(err.Error(), "isn't on the host") {
+		t.Errorf("Expected an error for the redirect, got %v", err)
+	}
+	if requested {
+		t.Error("Expected the redirect not to be followed")
+	}
+}
+
+func TestBundleRepeatedReferences(t *testing.T) {
+	// Every level references the level below twice, which doubles the size of the document with every level
+	files := map[string]string{"/level0.json": `{"type": "string", "description": "` + strings.Repeat("x", 100) + `"}`}
+	for i := 1; i <= 20; i++ {
+		files[fmt.Sprintf("/level%d.json", i)] = fmt.Sprintf(`{"allOf": [{"$ref": "./level%d.json"}, {"$ref": "./level%d.json"}]}`, i-1, i-1)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(files[r.URL.Path]))
+	}))
+	defer server.Close()
+
+	load := func(ctx context.Context, url string, maxSize int) (string, error) {
+		return GetAPIDoc(ctx, url, nil, maxSize)
+	}
+	openapi := `{"openapi": "3.0.3", "components": {"schemas": {"Deep": {"$ref": "./level20.json"}}}}`
+	if _, err := Bundle(context.Background(), openapi, server.URL+"/openapi.json", load, 50, 1024, 1024*1024, nil); err == nil || !strings.Contains(err.Error(), "more than 1048576 bytes") {
+		t.Fatalf("Expected an error for the repeated references, got %v", err)
+	}
+}
@@ -0,0 +1,37 @@
+// Package util implements utility methods
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+)
+
+// ConvertToOpenAPI3 converts a Swagger 2.0 document to OpenAPI 3.0. The definitions become components, consumes and
+// produces become the content of request and response bodies, and host, basePath and schemes become servers. Like
+// WriteSwaggerToDisk, the host of the document is replaced by svchost first.
+func ConvertToOpenAPI3(apidoc string, svchost string) (string, error) {
+	var doc openapi2.T
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return "", fmt.Errorf("error while unmarshaling JSON: %s", err.Error())
+	}
+
+	// Update the host information
+	if len(doc.Host) > 0 {
+		doc.Host = svchost
+	}
+
+	converted, err := openapi2conv.ToV3(&doc)
+	if err != nil {
+		return "", fmt.Errorf("error while converting to OpenAPI 3.0: %s", err.Error())
+	}
+
+	apibytes, err := json.Marshal(converted)
+	if err != nil {
+		return "", fmt.Errorf("error while marshaling API: %s", err.Error())
+	}
+
+	return string(apibytes), nil
+}
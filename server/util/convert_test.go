@@ -0,0 +1,47 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestConvertToOpenAPI3(t *testing.T) {
+	swagger := `{
+		"swagger": "2.0",
+		"info": {"title": "invoices", "version": "1.0.0"},
+		"host": "10.0.0.1:8080",
+		"basePath": "/api",
+		"schemes": ["https"],
+		"produces": ["application/json"],
+		"paths": {"/invoices": {"get": {"responses": {"200": {"description": "OK", "schema": {"$ref": "#/definitions/Invoice"}}}}}},
+		"definitions": {"Invoice": {"type": "object"}}
+	}`
+
+	apidoc, err := ConvertToOpenAPI3(swagger, "api.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		OpenAPI    string `json:"openapi"`
+		Servers    []struct{ URL string }
+		Components struct{ Schemas map[string]interface{} }
+		Paths      map[string]map[string]struct {
+			Responses map[string]struct{ Content map[string]interface{} }
+		}
+	}
+	json.Unmarshal([]byte(apidoc), &doc)
+
+	if doc.OpenAPI != "3.0.3" {
+		t.Errorf("Unexpected version %s", doc.OpenAPI)
+	}
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != "https://api.example.com/api" {
+		t.Errorf("Unexpected servers %v", doc.Servers)
+	}
+	if _, ok := doc.Components.Schemas["Invoice"]; !ok {
+		t.Errorf("Expected the definitions to be converted to components")
+	}
+	if _, ok := doc.Paths["/invoices"]["get"].Responses["200"].Content["application/json"]; !ok {
+		t.Errorf("Expected produces to be converted to content")
+	}
+}
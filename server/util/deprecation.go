@@ -0,0 +1,242 @@
+// Package util implements utility methods
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A template for the Markdown file of the deprecations in Hugo
+const deprecationsMarkdown = `---
+title: Deprecations
+weight: 30
+---
+
+{{.body}}`
+
+// The formats in which deprecation and sunset dates are accepted, the last one is how the Sunset header of RFC 8594
+// formats them
+var dateFormats = []string{"2006-01-02", time.RFC3339, http.TimeFormat}
+
+// Deprecation is an API or an operation of an API that is going away
+type Deprecation struct {
+	// The name of the API
+	API string `json:"api"`
+	// The name of the page of the API
+	Page string `json:"-"`
+	// The group (like a cluster) in which the page of the API is written
+	Group string `json:"cluster,omitempty"`
+	// The namespace of the API
+	Namespace string `json:"namespace,omitempty"`
+	// The operation (like GET /invoices), empty when the API as a whole is deprecated
+	Operation string `json:"operation,omitempty"`
+	// The date from which the API or operation is deprecated, when it is known
+	Deprecation *time.Time `json:"deprecation,omitempty"`
+	// The date on which the API or operation will be removed, when it is known
+	Sunset *time.Time `json:"sunset,omitempty"`
+}
+
+// ParseDate parses a deprecation or sunset date, which is a date (like 2027-01-31), a timestamp in RFC 3339 or an HTTP
+// date
+func ParseDate(value string) (*time.Time, error) {
+	for _, format := range dateFormats {
+		if date, err := time.Parse(format, value); err == nil {
+			return &date, nil
+		}
+	}
+	return nil, fmt.Errorf("%s isn't a date like 2027-01-31", value)
+}
+
+// FindDeprecations returns the deprecated operations of a Swagger 2.0 or OpenAPI 3 document, and the API itself when
+// its lifecycle is deprecated or it has a deprecation or sunset date. The dates of the API are the deprecation and
+// sunset arguments, or otherwise the x-sunset extension of the info of the document. Operations are deprecated when
+// they are marked as deprecated or have an x-sunset extension. Dates that can't be parsed are ignored.
+func FindDeprecations(apidoc string, lifecycle string, deprecation string, sunset string) []Deprecation {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return nil
+	}
+
+	var deprecations []Deprecation
+	info, _ := doc["info"].(map[string]interface{})
+	if len(sunset) == 0 {
+		sunset, _ = info["x-sunset"].(string)
+	}
+	api := Deprecation{Deprecation: parseDate(deprecation, "deprecation date of the API"), Sunset: parseDate(sunset, "sunset date of the API")}
+	if lifecycle == LifecycleDeprecated || api.Deprecation != nil || api.Sunset != nil {
+		deprecations = append(deprecations, api)
+	}
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	for _, p := range sortedKeys(paths) {
+		item, _ := paths[p].(map[string]interface{})
+		for _, method := range methods {
+			operation, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			name := fmt.Sprintf("%s %s", strings.ToUpper(method), p)
+			deprecated, _ := operation["deprecated"].(bool)
+			sunset, _ := operation["x-sunset"].(string)
+			if deprecated || len(sunset) > 0 {
+				deprecations = append(deprecations, Deprecation{Operation: name, Sunset: parseDate(sunset, "sunset date of "+name)})
+			}
+		}
+	}
+
+	return deprecations
+}
+
+// parseDate parses a date that is optional, logging the dates that can't be parsed
+func parseDate(value string, what string) *time.Time {
+	if len(value) == 0 {
+		return nil
+	}
+	date, err := ParseDate(value)
+	if err != nil {
+		log.Printf("Ignoring the %s: %s", what, err.Error())
+	}
+	return date
+}
+
+// formatDate formats a date for a page, or returns a dash when it isn't known
+func formatDate(date *time.Time) string {
+	if date == nil {
+		return "-"
+	}
+	return date.Format("2006-01-02")
+}
+
+// deprecationsNotice renders the deprecations of an API as a warning using the notice shortcode of the theme
+func deprecationsNotice(deprecations []Deprecation) string {
+	if len(deprecations) == 0 {
+		return ""
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("{{% notice warning %}}\n")
+	for _, deprecation := range deprecations {
+		if len(deprecation.Operation) > 0 {
+			continue
+		}
+		buf.WriteString("This API is deprecated")
+		if deprecation.Deprecation != nil {
+			buf.WriteString(fmt.Sprintf(" since %s", formatDate(deprecation.Deprecation)))
+		}
+		if deprecation.Sunset != nil {
+			buf.WriteString(fmt.Sprintf(" and will be removed on %s", formatDate(deprecation.Sunset)))
+		} else {
+			buf.WriteString(" and will be removed")
+		}
+		buf.WriteString(".\n\n")
+	}
+	for _, deprecation := range deprecations {
+		if len(deprecation.Operation) == 0 {
+			continue
+		}
+		if deprecation.Sunset != nil {
+			buf.WriteString(fmt.Sprintf("* `%s` is deprecated and will be removed on %s\n", deprecation.Operation, formatDate(deprecation.Sunset)))
+		} else {
+			buf.WriteString(fmt.Sprintf("* `%s` is deprecated\n", deprecation.Operation))
+		}
+	}
+	buf.WriteString("{{% /notice %}}\n\n")
+	return buf.String()
+}
+
+// WriteDeprecationsToDisk writes the Markdown file of the deprecations to the section of the listings in Hugo, which
+// lists the upcoming sunsets by date followed by the sunsets that have passed and the deprecations without a sunset
+// date. The upcoming sunsets are also written as JSON to the store of the OpenAPI documents, so they can be retrieved
+// from the site.
+func WriteDeprecationsToDisk(deprecations []Deprecation, now time.Time, hugoStore string, swaggerStore string) error {
+	sort.SliceStable(deprecations, func(i, j int) bool {
+		a, b := deprecations[i], deprecations[j]
+		if (a.Sunset == nil) != (b.Sunset == nil) {
+			return a.Sunset != nil
+		}
+		if a.Sunset != nil && !a.Sunset.Equal(*b.Sunset) {
+			return a.Sunset.Before(*b.Sunset)
+		}
+		if a.API != b.API {
+			return a.API < b.API
+		}
+		return a.Operation < b.Operation
+	})
+
+	var upcoming, passed, undated []Deprecation
+	for _, deprecation := range deprecations {
+		switch {
+		case deprecation.Sunset == nil:
+			undated = append(undated, deprecation)
+		case deprecation.Sunset.Before(now):
+			passed = append(passed, deprecation)
+		default:
+			upcoming = append(upcoming, deprecation)
+		}
+	}
+
+	var buf bytes.Buffer
+	// The page is at the same level as the page of an API in the section of the listings
+	buf.WriteString(fmt.Sprintf("The upcoming sunsets are also available as [JSON](%sswaggerdocs/%s/deprecations.json).\n\n", relativeRoot(ListingsDir), ListingsDir))
+	for _, section := range []struct {
+		title        string
+		deprecations []Deprecation
+	}{
+		{"Upcoming sunsets", upcoming},
+		{"Passed sunsets", passed},
+		{"Deprecated without a sunset date", undated},
+	} {
+		if len(section.deprecations) == 0 {
+			continue
+		}
+		buf.WriteString(fmt.Sprintf("## %s\n\n", section.title))
+		buf.WriteString("| Sunset | API | Operation | Namespace | Deprecated since |\n|---|---|---|---|---|\n")
+		for _, deprecation := range section.deprecations {
+			link := path.Join("../..", deprecation.Group, strings.Replace(strings.ToLower(deprecation.Page), " ", "-", -1)) + "/"
+			operation := "-"
+			if len(deprecation.Operation) > 0 {
+				operation = fmt.Sprintf("`%s`", deprecation.Operation)
+			}
+			buf.WriteString(fmt.Sprintf("| %s | [%s](%s) | %s | %s | %s |\n", formatDate(deprecation.Sunset), deprecation.API, link, operation,
+				namespaceName(deprecation.Namespace), formatDate(deprecation.Deprecation)))
+		}
+		buf.WriteString("\n")
+	}
+	if len(deprecations) == 0 {
+		buf.WriteString("Nothing in the catalog is deprecated.\n")
+	}
+
+	if err := writeMarkdown(deprecationsMarkdown, map[string]interface{}{"body": buf.String()}, filepath.Join(hugoStore, ListingsDir), "deprecations.md"); err != nil {
+		return err
+	}
+
+	// Write the upcoming sunsets as JSON
+	if upcoming == nil {
+		upcoming = []Deprecation{}
+	}
+	sunsets, err := json.MarshalIndent(map[string]interface{}{"generated": now.UTC(), "sunsets": upcoming}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error while marshaling sunsets: %s", err.Error())
+	}
+	dir := filepath.Join(swaggerStore, ListingsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("error while creating directory: %s", err.Error())
+		return fmt.Errorf("error while creating directory: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "deprecations.json"), sunsets, 0644); err != nil {
+		log.Printf("error while writing sunsets to disk: %s", err.Error())
+		return fmt.Errorf("error while writing sunsets to disk: %s", err.Error())
+	}
+
+	return nil
+}
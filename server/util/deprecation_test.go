@@ -0,0 +1,91 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindDeprecations(t *testing.T) {
+	apidoc := `{
+		"openapi": "3.0.0",
+		"info": {"title": "Invoices", "x-sunset": "2027-06-30"},
+		"paths": {
+			"/invoices": {
+				"get": {"responses": {}},
+				"delete": {"deprecated": true, "x-sunset": "Sat, 31 Jul 2027 00:00:00 GMT", "responses": {}}
+			},
+			"/reports": {"get": {"deprecated": true, "responses": {}}}
+		}
+	}`
+
+	deprecations := FindDeprecations(apidoc, LifecycleStable, "2026-10-01", "")
+	if len(deprecations) != 3 {
+		t.Fatalf("Expected 3 deprecations, got %+v", deprecations)
+	}
+	if api := deprecations[0]; len(api.Operation) > 0 || formatDate(api.Deprecation) != "2026-10-01" || formatDate(api.Sunset) != "2027-06-30" {
+		t.Errorf("Unexpected deprecation of the API %+v", api)
+	}
+	if operation := deprecations[1]; operation.Operation != "DELETE /invoices" || formatDate(operation.Sunset) != "2027-07-31" {
+		t.Errorf("Unexpected deprecation of the operation %+v", operation)
+	}
+	if operation := deprecations[2]; operation.Operation != "GET /reports" || operation.Sunset != nil {
+		t.Errorf("Unexpected deprecation of the operation %+v", operation)
+	}
+
+	// A deprecated API without dates is deprecated as a whole, an invalid date is ignored
+	deprecations = FindDeprecations(`{"swagger": "2.0", "paths": {}}`, LifecycleDeprecated, "", "next year")
+	if len(deprecations) != 1 || deprecations[0].Sunset != nil {
+		t.Errorf("Unexpected deprecations %+v", deprecations)
+	}
+}
+
+func TestWriteDeprecationsToDisk(t *testing.T) {
+	store := t.TempDir()
+	date := func(value string) *time.Time {
+		d, _ := ParseDate(value)
+		return d
+	}
+
+	deprecations := []Deprecation{
+		{API: "Reports", Page: "reports", Operation: "GET /reports"},
+		{API: "Invoices", Page: "invoices", Group: "prod", Operation: "DELETE /invoices", Sunset: date("2027-07-31")},
+		{API: "Payments", Page: "payments", Sunset: date("2026-01-01")},
+		{API: "Invoices", Page: "invoices", Group: "prod", Sunset: date("2027-06-30")},
+	}
+	if err := WriteDeprecationsToDisk(deprecations, time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC), store, store); err != nil {
+		t.Fatal(err)
+	}
+
+	// The upcoming sunsets are listed by date
+	content, err := ioutil.ReadFile(filepath.Join(store, ListingsDir, "deprecations.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sunsets struct {
+		Sunsets []Deprecation `json:"sunsets"`
+	}
+	json.Unmarshal(content, &sunsets)
+	if len(sunsets.Sunsets) != 2 || len(sunsets.Sunsets[0].Operation) > 0 || sunsets.Sunsets[1].Operation != "DELETE /invoices" {
+		t.Errorf("Unexpected sunsets %s", content)
+	}
+
+	content, err = ioutil.ReadFile(filepath.Join(store, ListingsDir, "deprecations.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, text := range []string{
+		"## Upcoming sunsets",
+		"[JSON](../../../../swaggerdocs/catalog/deprecations.json)",
+		"| 2027-06-30 | [Invoices](../../prod/invoices/) | - | - | - |",
+		"## Passed sunsets\n\n| Sunset | API | Operation | Namespace | Deprecated since |\n|---|---|---|---|---|\n| 2026-01-01 | [Payments](../../payments/)",
+		"## Deprecated without a sunset date\n\n| Sunset | API | Operation | Namespace | Deprecated since |\n|---|---|---|---|---|\n| - | [Reports](../../reports/) | `GET /reports` |",
+	} {
+		if !strings.Contains(string(content), text) {
+			t.Errorf("Expected the page to contain %s, got:\n%s", text, content)
+		}
+	}
+}
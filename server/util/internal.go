@@ -0,0 +1,317 @@
+// Package util implements utility methods
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The sections with reusable components, by the prefix of references to them
+var componentSections = map[string][]string{
+	"#/definitions/":                {"definitions"},
+	"#/parameters/":                 {"parameters"},
+	"#/responses/":                  {"responses"},
+	"#/components/schemas/":         {"components", "schemas"},
+	"#/components/parameters/":      {"components", "parameters"},
+	"#/components/responses/":       {"components", "responses"},
+	"#/components/requestBodies/":   {"components", "requestBodies"},
+	"#/components/headers/":         {"components", "headers"},
+	"#/components/examples/":        {"components", "examples"},
+	"#/components/links/":           {"components", "links"},
+	"#/components/callbacks/":       {"components", "callbacks"},
+	"#/components/securitySchemes/": {"components", "securitySchemes"},
+}
+
+// StripInternal removes the paths, operations, parameters, schemas and properties that have the extension set to true
+// from a Swagger 2.0 or OpenAPI 3 document, together with everything that references them. When anything was
+// removed, the components that are no longer referenced because of it are removed as well and true is returned. The
+// document is returned unchanged when it isn't JSON.
+func StripInternal(apidoc string, extension string) (string, bool, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return apidoc, false, nil
+	}
+
+	s := &stripper{extension: extension, removed: make(map[string]bool)}
+
+	// Only the components that are referenced now are pruned when they are no longer referenced after stripping
+	referenced := referencedComponents(doc)
+
+	// Remove the internal paths and operations, and the internal parameters of the others
+	paths, _ := doc["paths"].(map[string]interface{})
+	for p, i := range paths {
+		item, _ := i.(map[string]interface{})
+		if s.isInternal(item) {
+			delete(paths, p)
+			s.stripped = true
+			continue
+		}
+		operations, removed := 0, 0
+		for _, method := range methods {
+			operation, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if s.isInternal(operation) {
+				delete(item, method)
+				s.stripped = true
+				removed++
+				continue
+			}
+			operations++
+		}
+		// A path whose operations are all internal has nothing left to publish
+		if removed > 0 && operations == 0 {
+			delete(paths, p)
+		}
+	}
+
+	// Remove the internal components
+	for prefix, section := range componentSections {
+		components := lookup(doc, section)
+		for name, c := range components {
+			if component, ok := c.(map[string]interface{}); ok && s.isInternal(component) {
+				delete(components, name)
+				s.removed[prefix+escapePointer(name)] = true
+				s.stripped = true
+			}
+		}
+	}
+
+	// Remove the internal parameters and properties wherever they are, and whatever references removed components
+	doc, _ = s.strip(doc).(map[string]interface{})
+	if !s.stripped {
+		return apidoc, false, nil
+	}
+
+	pruneComponents(doc, referenced)
+
+	apibytes, err := json.Marshal(doc)
+	if err != nil {
+		return "", false, fmt.Errorf("error while marshaling stripped document: %s", err.Error())
+	}
+	return string(apibytes), true, nil
+}
+
+// stripper removes the internal parts of a document
+type stripper struct {
+	// The extension that marks the internal parts
+	extension string
+	// The references to the components that were removed
+	removed map[string]bool
+	// Whether anything was removed
+	stripped bool
+}
+
+// isInternal checks whether a node of the document has the extension set to true
+func (s *stripper) isInternal(node interface{}) bool {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	internal, _ := m[s.extension].(bool)
+	return internal
+}
+
+// isRemoved checks whether a node of the document references a component that was removed
+func (s *stripper) isRemoved(node interface{}) bool {
+	m, ok := node.(map[string]interface{})
+	if !ok {
+		return false
+	}
+	ref, _ := m["$ref"].(string)
+	return s.removed[ref]
+}
+
+// strip removes the internal parameters, the internal properties of schemas and the references to removed components
+// from a node of the document
+func (s *stripper) strip(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		// Internal properties and properties that reference removed components are removed from the required
+		// properties as well
+		if properties, ok := n["properties"].(map[string]interface{}); ok {
+			for name, property := range properties {
+				if s.isInternal(property) || s.isRemoved(property) {
+					delete(properties, name)
+					n["required"] = without(n["required"], name)
+					s.stripped = true
+				}
+			}
+		}
+		for key, value := range n {
+			if s.isRemoved(value) {
+				delete(n, key)
+				continue
+			}
+			n[key] = s.strip(value)
+		}
+		return n
+	case []interface{}:
+		items := []interface{}{}
+		for _, item := range n {
+			if s.isRemoved(item) {
+				continue
+			}
+			// Only parameters are removed from lists, schemas in allOf and the like are kept
+			if s.isInternal(item) {
+				if m, _ := item.(map[string]interface{}); m["in"] != nil {
+					s.stripped = true
+					continue
+				}
+			}
+			items = append(items, s.strip(item))
+		}
+		return items
+	default:
+		return node
+	}
+}
+
+// pruneComponents removes the components that were referenced before the document was stripped, but no longer are.
+// Components that weren't referenced to begin with are kept, as they are published on purpose.
+func pruneComponents(doc map[string]interface{}, before map[string]bool) {
+	referenced := referencedComponents(doc)
+	for prefix, section := range componentSections {
+		components := lookup(doc, section)
+		for name := range components {
+			ref := prefix + escapePointer(name)
+			if before[ref] && !referenced[ref] {
+				delete(components, name)
+			}
+		}
+	}
+}
+
+// referencedComponents returns the references to the components that are referenced from outside the components,
+// directly or through other components. Security schemes are referenced by name rather than by reference, so they
+// are always referenced.
+func referencedComponents(doc map[string]interface{}) map[string]bool {
+	// Collect the references from outside the components
+	referenced := make(map[string]bool)
+	var outside []interface{}
+	for key, value := range doc {
+		if key != "definitions" && key != "parameters" && key != "responses" && key != "components" {
+			outside = append(outside, value)
+		}
+	}
+	if components, ok := doc["components"].(map[string]interface{}); ok {
+		outside = append(outside, components["securitySchemes"])
+	}
+	pending := collectRefs(outside, nil)
+
+	// Follow the references through the components until no new ones are found
+	for len(pending) > 0 {
+		ref := pending[0]
+		pending = pending[1:]
+		if referenced[ref] {
+			continue
+		}
+		referenced[ref] = true
+		for prefix, section := range componentSections {
+			if strings.HasPrefix(ref, prefix) {
+				name := unescapePointer(strings.SplitN(strings.TrimPrefix(ref, prefix), "/", 2)[0])
+				pending = collectRefs(lookup(doc, section)[name], pending)
+			}
+		}
+	}
+
+	for name := range lookup(doc, componentSections["#/components/securitySchemes/"]) {
+		referenced["#/components/securitySchemes/"+escapePointer(name)] = true
+	}
+	return referenced
+}
+
+// collectRefs appends the local references in a node of the document, without their fragment within the component
+func collectRefs(node interface{}, refs []string) []string {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for key, value := range n {
+			if ref, ok := value.(string); ok && key == "$ref" && strings.HasPrefix(ref, "#/") {
+				for prefix := range componentSections {
+					if strings.HasPrefix(ref, prefix) {
+						name := strings.SplitN(strings.TrimPrefix(ref, prefix), "/", 2)[0]
+						refs = append(refs, prefix+name)
+					}
+				}
+				continue
+			}
+			refs = collectRefs(value, refs)
+		}
+	case []interface{}:
+		for _, item := range n {
+			refs = collectRefs(item, refs)
+		}
+	}
+	return refs
+}
+
+// lookup returns the object at the keys in the document, or nil when there is none
+func lookup(doc map[string]interface{}, keys []string) map[string]interface{} {
+	node := doc
+	for _, key := range keys {
+		node, _ = node[key].(map[string]interface{})
+	}
+	return node
+}
+
+// without returns the list of strings without the value
+func without(list interface{}, value string) interface{} {
+	items, ok := list.([]interface{})
+	if !ok {
+		return list
+	}
+	remaining := []interface{}{}
+	for _, item := range items {
+		if item != value {
+			remaining = append(remaining, item)
+		}
+	}
+	return remaining
+}
+
+// escapePointer escapes a key to use it in a JSON pointer
+func escapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~", "~0", -1), "/", "~1", -1)
+}
+
+// unescapePointer returns the key that was escaped in a JSON pointer
+func unescapePointer(key string) string {
+	return strings.Replace(strings.Replace(key, "~1", "/", -1), "~0", "~", -1)
+}
+
+// InternalFilename returns the name of the file in the directory in which the full document of an API with internal
+// parts is stored
+func InternalFilename(dir string, group string, name string) string {
+	return filepath.Join(dir, group, fmt.Sprintf("%s.json", strings.Replace(strings.ToLower(name), " ", "-", -1)))
+}
+
+// WriteInternalToDisk writes the full document of an API with internal parts to the directory for the restricted
+// audience, with the host updated like the published document
+func WriteInternalToDisk(name string, group string, apidoc string, svchost string, internalStore string) error {
+	filename := InternalFilename(internalStore, group, name)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("error while creating directory: %s", err.Error())
+	}
+
+	var swagger map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &swagger); err != nil {
+		return fmt.Errorf("error while unmarshaling JSON: %s", err.Error())
+	}
+	if _, ok := swagger["host"]; ok {
+		swagger["host"] = svchost
+	}
+
+	apibytes, err := json.Marshal(swagger)
+	if err != nil {
+		return fmt.Errorf("error while marshaling API: %s", err.Error())
+	}
+	if err := ioutil.WriteFile(filename, apibytes, 0644); err != nil {
+		return fmt.Errorf("error while writing OpenAPI to disk: %s", err.Error())
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestStripInternal(t *testing.T) {
+	apidoc := `{
+		"openapi": "3.0.0",
+		"paths": {
+			"/invoices": {
+				"get": {
+					"parameters": [{"name": "limit", "in": "query"}, {"name": "debug", "in": "query", "x-internal": true}, {"$ref": "#/components/parameters/Tenant"}],
+					"responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Invoice"}}}}}
+				},
+				"delete": {"x-internal": true, "responses": {"204": {"description": "Deleted", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Purge"}}}}}}
+			},
+			"/admin": {"x-internal": true, "get": {"responses": {"200": {"description": "OK"}}}},
+			"/reindex": {"post": {"x-internal": true, "responses": {"202": {"description": "Accepted"}}}}
+		},
+		"components": {
+			"parameters": {"Tenant": {"name": "tenant", "in": "header", "x-internal": true}},
+			"schemas": {
+				"Invoice": {"type": "object", "required": ["id", "cost", "audit"], "properties": {"id": {"$ref": "#/components/schemas/Id"}, "cost": {"type": "number", "x-internal": true}, "audit": {"$ref": "#/components/schemas/Audit"}}},
+				"Id": {"type": "string"},
+				"Audit": {"type": "object", "x-internal": true},
+				"Purge": {"type": "object", "properties": {"count": {"$ref": "#/components/schemas/Count"}}},
+				"Count": {"type": "integer"},
+				"Unused": {"type": "string"}
+			},
+			"securitySchemes": {"apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"}}
+		}
+	}`
+
+	// The properties that reference removed components are no longer required, and only the components that were
+	// referenced before stripping are pruned, so Count is removed with Purge but Unused is kept
+	stripped, ok, err := StripInternal(apidoc, "x-internal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Fatal("Expected the document to be stripped")
+	}
+
+	expected := `{
+		"openapi": "3.0.0",
+		"paths": {
+			"/invoices": {
+				"get": {
+					"parameters": [{"name": "limit", "in": "query"}],
+					"responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Invoice"}}}}}
+				}
+			}
+		},
+		"components": {
+			"parameters": {},
+			"schemas": {
+				"Invoice": {"type": "object", "required": ["id"], "properties": {"id": {"$ref": "#/components/schemas/Id"}}},
+				"Id": {"type": "string"},
+				"Unused": {"type": "string"}
+			},
+			"securitySchemes": {"apiKey": {"type": "apiKey", "in": "header", "name": "X-API-Key"}}
+		}
+	}`
+	var actualDoc, expectedDoc interface{}
+	json.Unmarshal([]byte(stripped), &actualDoc)
+	json.Unmarshal([]byte(expected), &expectedDoc)
+	if !reflect.DeepEqual(actualDoc, expectedDoc) {
+		t.Errorf("Expected %s, got %s", expected, stripped)
+	}
+
+	// Documents without internal parts are published as they are, including their unused components
+	public := `{"swagger": "2.0", "paths": {}, "definitions": {"Unused": {"type": "string"}}}`
+	if unchanged, ok, _ := StripInternal(public, "x-internal"); ok || unchanged != public {
+		t.Errorf("Expected the document to be unchanged, got %s", unchanged)
+	}
+}
@@ -0,0 +1,255 @@
+// Package util implements utility methods
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Severity is how important a lint rule is
+type Severity string
+
+const (
+	// SeverityError is for rules every API must follow
+	SeverityError Severity = "error"
+	// SeverityWarning is for rules APIs should follow
+	SeverityWarning Severity = "warning"
+	// SeverityInfo is for rules that are good practice
+	SeverityInfo Severity = "info"
+	// SeverityOff disables a rule
+	SeverityOff Severity = "off"
+)
+
+// The lint rules with their default severity
+var defaultRules = map[string]Severity{
+	"operation-id":          SeverityError,
+	"operation-id-unique":   SeverityError,
+	"operation-description": SeverityWarning,
+	"kebab-case-paths":      SeverityWarning,
+	"error-responses":       SeverityWarning,
+	"security":              SeverityWarning,
+	"examples":              SeverityInfo,
+}
+
+// The HTTP methods of the operations of a path
+var methods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// A path segment in kebab-case, like invoice-items or swagger.json
+var kebabCase = regexp.MustCompile(`^[a-z0-9]+([-.][a-z0-9]+)*$`)
+
+// LintResult is a place where an OpenAPI document doesn't follow a lint rule
+type LintResult struct {
+	// The rule that isn't followed
+	Rule string `json:"rule"`
+	// The severity of the rule
+	Severity Severity `json:"severity"`
+	// The location in the document (like paths./invoices.get), empty when it applies to the whole document
+	Location string `json:"location,omitempty"`
+	// A human readable explanation of the result
+	Message string `json:"message"`
+}
+
+// Ruleset configures the severity of the lint rules, both for all namespaces and for specific namespaces
+type Ruleset struct {
+	// The severity of rules for all namespaces, by rule
+	Rules map[string]Severity `json:"rules,omitempty"`
+	// The severity of rules for specific namespaces, by namespace and rule
+	Namespaces map[string]map[string]Severity `json:"namespaces,omitempty"`
+}
+
+// LoadRuleset reads a ruleset from a JSON or YAML file
+func LoadRuleset(filename string) (*Ruleset, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading ruleset: %s", err.Error())
+	}
+
+	var ruleset Ruleset
+	if err := yaml.Unmarshal(data, &ruleset); err != nil {
+		return nil, fmt.Errorf("error while parsing ruleset: %s", err.Error())
+	}
+
+	// Check the rules, so a typo doesn't go unnoticed
+	rules := []map[string]Severity{ruleset.Rules}
+	for _, namespace := range ruleset.Namespaces {
+		rules = append(rules, namespace)
+	}
+	for _, r := range rules {
+		for rule, severity := range r {
+			// YAML reads an unquoted off as false
+			if severity == "false" {
+				severity = SeverityOff
+				r[rule] = severity
+			}
+			if _, ok := defaultRules[rule]; !ok {
+				return nil, fmt.Errorf("unknown rule %s in ruleset", rule)
+			}
+			switch severity {
+			case SeverityError, SeverityWarning, SeverityInfo, SeverityOff:
+			default:
+				return nil, fmt.Errorf("unknown severity %s for rule %s in ruleset", severity, rule)
+			}
+		}
+	}
+
+	return &ruleset, nil
+}
+
+// For returns the severity of every rule in the namespace. The ruleset can be nil, in which case the default
+// severities are returned.
+func (r *Ruleset) For(namespace string) map[string]Severity {
+	rules := make(map[string]Severity)
+	for rule, severity := range defaultRules {
+		rules[rule] = severity
+	}
+	if r == nil {
+		return rules
+	}
+	for rule, severity := range r.Rules {
+		rules[rule] = severity
+	}
+	for rule, severity := range r.Namespaces[namespace] {
+		rules[rule] = severity
+	}
+	return rules
+}
+
+// Lint checks the Swagger 2.0 or OpenAPI 3 document against the rules and returns the results, ordered by location
+func Lint(apidoc string, rules map[string]Severity) []LintResult {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return nil
+	}
+
+	var results []LintResult
+	report := func(rule string, location string, format string, args ...interface{}) {
+		if severity, ok := rules[rule]; ok && severity != SeverityOff {
+			results = append(results, LintResult{Rule: rule, Severity: severity, Location: location, Message: fmt.Sprintf(format, args...)})
+		}
+	}
+
+	security, _ := doc["security"].([]interface{})
+	operationIDs := make(map[string]string)
+
+	paths, _ := doc["paths"].(map[string]interface{})
+	for _, p := range sortedKeys(paths) {
+		// Path parameters can be named any way
+		for _, segment := range strings.Split(p, "/") {
+			if len(segment) > 0 && !strings.HasPrefix(segment, "{") && !kebabCase.MatchString(segment) {
+				report("kebab-case-paths", "paths."+p, "the path segment %s isn't in kebab-case", segment)
+			}
+		}
+
+		item, _ := paths[p].(map[string]interface{})
+		for _, method := range methods {
+			operation, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			location := fmt.Sprintf("paths.%s.%s", p, method)
+
+			operationID, _ := operation["operationId"].(string)
+			if len(operationID) == 0 {
+				report("operation-id", location, "the operation has no operationId")
+			} else if other, ok := operationIDs[operationID]; ok {
+				report("operation-id-unique", location, "the operationId %s is also used by %s", operationID, other)
+			} else {
+				operationIDs[operationID] = location
+			}
+
+			summary, _ := operation["summary"].(string)
+			description, _ := operation["description"].(string)
+			if len(summary) == 0 && len(description) == 0 {
+				report("operation-description", location, "the operation has no summary or description")
+			}
+
+			responses, _ := operation["responses"].(map[string]interface{})
+			if !hasErrorResponse(responses) {
+				report("error-responses", location, "the operation defines no error responses (4xx, 5xx or default)")
+			}
+
+			if _, ok := operation["security"]; !ok && len(security) == 0 {
+				report("security", location, "the operation declares no security requirements")
+			}
+
+			if !hasExamples(responses) {
+				report("examples", location, "the successful responses of the operation have no examples")
+			}
+		}
+	}
+
+	return results
+}
+
+// hasErrorResponse checks whether the responses contain a 4xx, 5xx or default response
+func hasErrorResponse(responses map[string]interface{}) bool {
+	for code := range responses {
+		if strings.HasPrefix(code, "4") || strings.HasPrefix(code, "5") || code == "default" {
+			return true
+		}
+	}
+	return false
+}
+
+// hasExamples checks whether any of the successful responses has an example, either on the response itself (Swagger
+// 2.0), on its content (OpenAPI 3) or on its schema. References to shared responses are assumed to have examples.
+func hasExamples(responses map[string]interface{}) bool {
+	successful := false
+	for code, r := range responses {
+		if !strings.HasPrefix(code, "2") {
+			continue
+		}
+		response, _ := r.(map[string]interface{})
+		if _, ok := response["$ref"]; ok {
+			return true
+		}
+		if response["schema"] == nil && response["content"] == nil && response["examples"] == nil {
+			continue
+		}
+		successful = true
+		if _, ok := response["examples"]; ok {
+			return true
+		}
+		if hasExample(response["schema"]) {
+			return true
+		}
+		content, _ := response["content"].(map[string]interface{})
+		for _, m := range content {
+			media, _ := m.(map[string]interface{})
+			if _, ok := media["example"]; ok {
+				return true
+			}
+			if _, ok := media["examples"]; ok {
+				return true
+			}
+			if hasExample(media["schema"]) {
+				return true
+			}
+		}
+	}
+	// Operations without a successful response with a body have nothing to show an example of
+	return !successful
+}
+
+// hasExample checks whether a schema has an example
+func hasExample(s interface{}) bool {
+	schema, _ := s.(map[string]interface{})
+	_, ok := schema["example"]
+	return ok
+}
+
+// sortedKeys returns the keys of the map in alphabetical order
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,59 @@
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	swagger := `{
+		"swagger": "2.0",
+		"paths": {
+			"/invoiceItems/{id}": {
+				"get": {"operationId": "getInvoice", "responses": {"200": {"description": "OK", "schema": {"type": "object"}}}},
+				"delete": {"operationId": "getInvoice", "summary": "Delete an invoice", "security": [], "responses": {"204": {"description": "Deleted"}, "404": {"description": "Not found"}}}
+			}
+		}
+	}`
+
+	results := Lint(swagger, (*Ruleset)(nil).For("default"))
+	rules := make(map[string]int)
+	for _, result := range results {
+		rules[result.Rule]++
+	}
+
+	expected := map[string]int{
+		"kebab-case-paths":      1,
+		"operation-id-unique":   1,
+		"operation-description": 1,
+		"error-responses":       1,
+		"security":              1,
+		"examples":              1,
+	}
+	for rule, count := range expected {
+		if rules[rule] != count {
+			t.Errorf("Expected %d results for %s, got %d: %v", count, rule, rules[rule], results)
+		}
+	}
+	if len(results) != 6 {
+		t.Errorf("Expected 6 results, got %v", results)
+	}
+
+	// Rules can be turned off for a namespace
+	filename := filepath.Join(t.TempDir(), "ruleset.yml")
+	ioutil.WriteFile(filename, []byte("rules:\n  examples: warning\nnamespaces:\n  legacy:\n    kebab-case-paths: off\n"), 0644)
+	ruleset, err := LoadRuleset(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ruleset.For("legacy")["kebab-case-paths"] != SeverityOff || ruleset.For("default")["kebab-case-paths"] != SeverityWarning {
+		t.Errorf("Unexpected severities for kebab-case-paths")
+	}
+	if ruleset.For("legacy")["examples"] != SeverityWarning {
+		t.Errorf("Unexpected severity for examples")
+	}
+	if len(Lint(swagger, ruleset.For("legacy"))) != 5 {
+		t.Errorf("Expected the kebab-case-paths rule to be off")
+	}
+}
@@ -0,0 +1,119 @@
+// Package util implements utility methods
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The extension under which the metadata is added to the published OpenAPI document
+const metadataExtension = "x-apiscout"
+
+// Metadata is what apiscout knows about the deployment of an API, which is added to its published document as the
+// x-apiscout extension and shown at the top of its page
+type Metadata struct {
+	// The namespace in which the API was discovered
+	Namespace string `json:"namespace,omitempty"`
+	// The cluster in which the API was discovered
+	Cluster string `json:"cluster,omitempty"`
+	// The labels of the object the API was discovered from
+	Labels map[string]string `json:"labels,omitempty"`
+	// The workload that runs the API as kind/name (like Deployment/invoices)
+	Workload string `json:"workload,omitempty"`
+	// The image of the workload
+	Image string `json:"image,omitempty"`
+	// The tag (or digest) of the image of the workload
+	ImageTag string `json:"imageTag,omitempty"`
+	// The time at which the API was first indexed
+	Discovered time.Time `json:"discovered"`
+	// The URL the OpenAPI document was fetched from
+	SourceURL string `json:"sourceUrl,omitempty"`
+}
+
+// ImageTag returns the tag of an image, or its digest when it is pinned by digest. Images without a tag use latest.
+func ImageTag(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		return image[i+1:]
+	}
+	// The registry can have a port, so only a colon after the last slash separates the tag
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		return image[i+1:]
+	}
+	return "latest"
+}
+
+// RedactMetadata applies the redaction rules to the metadata where it is added to the published document (under the
+// x-apiscout extension), so neither the document nor the page shows metadata that the rules would redact from the
+// document itself. It returns nil when the rules remove the extension. The time at which the API was discovered is
+// set by apiscout, so it isn't redacted.
+func RedactMetadata(metadata *Metadata, rules *RedactionRules) (*Metadata, []Redaction, error) {
+	if metadata == nil || rules == nil {
+		return metadata, nil, nil
+	}
+
+	// Redact the metadata as part of a document with only the extension
+	var fields map[string]interface{}
+	data, _ := json.Marshal(metadata)
+	json.Unmarshal(data, &fields)
+	delete(fields, "discovered")
+	doc, err := json.Marshal(map[string]interface{}{metadataExtension: fields})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error while marshaling metadata: %s", err.Error())
+	}
+	redactedDoc, redactions, err := Redact(string(doc), rules)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var result map[string]*Metadata
+	if err := json.Unmarshal([]byte(redactedDoc), &result); err != nil {
+		return nil, nil, fmt.Errorf("error while unmarshaling redacted metadata: %s", err.Error())
+	}
+	redacted := result[metadataExtension]
+	if redacted != nil {
+		redacted.Discovered = metadata.Discovered
+	}
+	return redacted, redactions, nil
+}
+
+// metadataMarkdown renders the metadata of an API as a table at the top of its page
+func metadataMarkdown(metadata *Metadata) string {
+	if metadata == nil {
+		return ""
+	}
+
+	// Only the fields that are known get a column
+	var headers, cells []string
+	for _, field := range [][2]string{
+		{"Namespace", metadata.Namespace},
+		{"Cluster", metadata.Cluster},
+		{"Workload", metadata.Workload},
+		{"Image tag", metadata.ImageTag},
+		{"Discovered", metadata.Discovered.UTC().Format(time.RFC1123)},
+		{"Source", metadata.SourceURL},
+	} {
+		if len(field[1]) > 0 {
+			headers = append(headers, field[0])
+			cells = append(cells, field[1])
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("| %s |\n", strings.Join(headers, " | ")))
+	buf.WriteString(fmt.Sprintf("|%s\n", strings.Repeat("---|", len(headers))))
+	buf.WriteString(fmt.Sprintf("| %s |\n\n", strings.Join(cells, " | ")))
+
+	if len(metadata.Labels) > 0 {
+		var labels []string
+		for key, value := range metadata.Labels {
+			labels = append(labels, fmt.Sprintf("`%s=%s`", key, value))
+		}
+		sort.Strings(labels)
+		buf.WriteString(fmt.Sprintf("**Labels:** %s\n\n", strings.Join(labels, " ")))
+	}
+	return buf.String()
+}
@@ -0,0 +1,96 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestImageTag(t *testing.T) {
+	tags := map[string]string{
+		"invoice":                         "latest",
+		"invoice:1.1.0":                   "1.1.0",
+		"registry:5000/invoice":           "latest",
+		"registry:5000/invoice:1.1.0":     "1.1.0",
+		"invoice@sha256:0123456789abcdef": "sha256:0123456789abcdef",
+	}
+	for image, tag := range tags {
+		if actual := ImageTag(image); actual != tag {
+			t.Errorf("Expected tag %s for %s, got %s", tag, image, actual)
+		}
+	}
+}
+
+func TestWriteSwaggerToDiskWithMetadata(t *testing.T) {
+	store := t.TempDir()
+
+	metadata := &Metadata{
+		Namespace:  "billing",
+		Workload:   "Deployment/invoices",
+		ImageTag:   "1.1.0",
+		Labels:     map[string]string{"app": "invoices"},
+		Discovered: time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC),
+		SourceURL:  "http://10.0.0.1:80/swagger.json",
+	}
+	if _, err := WriteSwaggerToDisk("invoices", "", `{"swagger": "2.0", "info": {"title": "Invoices"}}`, "", store, store, Page{Metadata: metadata}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The metadata is added to the published document
+	content, _ := ioutil.ReadFile(filepath.Join(store, "invoices.json"))
+	var doc struct {
+		Metadata Metadata `json:"x-apiscout"`
+	}
+	json.Unmarshal(content, &doc)
+	if doc.Metadata.Workload != "Deployment/invoices" || !doc.Metadata.Discovered.Equal(metadata.Discovered) {
+		t.Errorf("Expected the metadata in the document, got %s", content)
+	}
+
+	// And shown at the top of the page
+	content, _ = ioutil.ReadFile(filepath.Join(store, "invoices.md"))
+	for _, text := range []string{"| Namespace | Workload | Image tag | Discovered | Source |", "| billing | Deployment/invoices | 1.1.0 |", "**Labels:** `app=invoices`"} {
+		if !strings.Contains(string(content), text) {
+			t.Errorf("Expected the page to contain %s, got:\n%s", text, content)
+		}
+	}
+}
+
+func TestRedactMetadata(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "redaction.yml")
+	ioutil.WriteFile(filename, []byte("paths:\n  - x-apiscout.labels.owner\nbuiltins: [ips]\n"), 0644)
+	rules, err := LoadRedactionRules(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	discovered := time.Date(2026, 10, 19, 8, 0, 0, 0, time.UTC)
+	metadata := &Metadata{
+		Namespace:  "billing",
+		Labels:     map[string]string{"app": "invoices", "owner": "jane"},
+		Discovered: discovered,
+		SourceURL:  "http://10.0.0.1:80/swagger.json",
+	}
+	redacted, redactions, err := RedactMetadata(metadata, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(redacted.SourceURL, "10.0.0.1") || len(redacted.Labels) != 1 || redacted.Labels["app"] != "invoices" {
+		t.Errorf("Expected the IP address and the owner to be redacted, got %+v", redacted)
+	}
+	if !redacted.Discovered.Equal(discovered) || redacted.Namespace != "billing" {
+		t.Errorf("Expected the other metadata to be kept, got %+v", redacted)
+	}
+	if len(redactions) != 2 || redactions[0].Location != "x-apiscout.labels.owner" || redactions[1].Location != "x-apiscout.sourceUrl" {
+		t.Errorf("Unexpected redactions %+v", redactions)
+	}
+
+	// The metadata is left out when the rules remove the extension
+	ioutil.WriteFile(filename, []byte("extensions:\n  - x-apiscout\n"), 0644)
+	rules, _ = LoadRedactionRules(filename)
+	if redacted, _, _ := RedactMetadata(metadata, rules); redacted != nil {
+		t.Errorf("Expected no metadata, got %+v", redacted)
+	}
+}
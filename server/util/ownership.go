@@ -0,0 +1,317 @@
+// Package util implements utility methods
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// The lifecycle stages of an API
+const (
+	// LifecycleExperimental is for APIs that can still change in incompatible ways
+	LifecycleExperimental = "experimental"
+	// LifecycleStable is for APIs that only change in compatible ways
+	LifecycleStable = "stable"
+	// LifecycleDeprecated is for APIs that will be removed
+	LifecycleDeprecated = "deprecated"
+)
+
+// The directory in the section of the listings in which the pages of the teams are written
+const teamsDir = "teams"
+
+// A template for the Markdown file of a team in Hugo
+const teamMarkdown = `---
+title: {{.title}}
+weight: {{.weight}}
+---
+
+{{.body}}`
+
+// Ownership is who to contact about an API
+type Ownership struct {
+	// The person or team that owns the API
+	Owner string `json:"owner,omitempty"`
+	// The team that owns the API
+	Team string `json:"team,omitempty"`
+	// The Slack channel to ask questions about the API in
+	Slack string `json:"slack,omitempty"`
+	// The URL of the repository with the source of the API
+	Repo string `json:"repo,omitempty"`
+	// The lifecycle stage of the API (can be either experimental, stable or deprecated)
+	Lifecycle string `json:"lifecycle,omitempty"`
+	// The email address to contact about the API
+	Email string `json:"email,omitempty"`
+	// The URL with contact information about the API
+	URL string `json:"url,omitempty"`
+}
+
+// OwnedAPI is an API on the page of its team
+type OwnedAPI struct {
+	// The name of the API
+	Name string
+	// The name of the page of the API
+	Page string
+	// The group (like a cluster) in which the page of the API is written
+	Group string
+	// The namespace of the API
+	Namespace string
+	// Who to contact about the API
+	Ownership Ownership
+}
+
+// ValidLifecycle checks whether the lifecycle is one of the known stages
+func ValidLifecycle(lifecycle string) bool {
+	return lifecycle == LifecycleExperimental || lifecycle == LifecycleStable || lifecycle == LifecycleDeprecated
+}
+
+// MergeContact completes the ownership with the contact in the info of the OpenAPI document, the ownership that was
+// already known takes precedence
+func (o Ownership) MergeContact(apidoc string) Ownership {
+	var doc struct {
+		Info struct {
+			Contact struct {
+				Name  string `json:"name"`
+				Email string `json:"email"`
+				URL   string `json:"url"`
+			} `json:"contact"`
+		} `json:"info"`
+	}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return o
+	}
+
+	if len(o.Owner) == 0 {
+		o.Owner = doc.Info.Contact.Name
+	}
+	if len(o.Email) == 0 {
+		o.Email = doc.Info.Contact.Email
+	}
+	if len(o.URL) == 0 {
+		o.URL = doc.Info.Contact.URL
+	}
+	return o
+}
+
+// TeamName returns the team an API is listed under, which is the owner when no team is known
+func (o Ownership) TeamName() string {
+	if len(o.Team) > 0 {
+		return o.Team
+	}
+	return o.Owner
+}
+
+// frontMatter renders the known ownership as YAML for the front matter of the page of an API, quoting the values as
+// JSON strings (which are valid YAML) as they come from annotations and documents
+func (o Ownership) frontMatter() string {
+	var buf bytes.Buffer
+	for _, field := range [][2]string{
+		{"owner", o.Owner},
+		{"team", o.Team},
+		{"slack", o.Slack},
+		{"repo", o.Repo},
+		{"lifecycle", o.Lifecycle},
+		{"email", o.Email},
+	} {
+		if len(field[1]) > 0 {
+			value, _ := json.Marshal(field[1])
+			buf.WriteString(fmt.Sprintf("%s: %s\n", field[0], value))
+		}
+	}
+	return buf.String()
+}
+
+// ownershipMarkdown renders who to contact about an API, and a notice when the API is experimental
+func ownershipMarkdown(o Ownership, group string) string {
+	var fields []string
+	if len(o.Owner) > 0 {
+		fields = append(fields, fmt.Sprintf("**Owner:** %s", markdownText(o.Owner)))
+	}
+	if team := o.TeamName(); len(team) > 0 {
+		fields = append(fields, fmt.Sprintf("**Team:** [%s](%s%s/%s/%s/)", markdownText(team), storeRoot(group), ListingsDir, teamsDir, teamSlug(team)))
+	}
+	if len(o.Email) > 0 {
+		fields = append(fields, fmt.Sprintf("**Email:** %s", markdownLink(o.Email, "mailto:"+o.Email)))
+	}
+	if len(o.Slack) > 0 {
+		fields = append(fields, fmt.Sprintf("**Slack:** %s", slackChannel(o.Slack)))
+	}
+	if len(o.Repo) > 0 {
+		fields = append(fields, fmt.Sprintf("**Repository:** %s", markdownLink(o.Repo, o.Repo)))
+	}
+	if len(o.URL) > 0 {
+		fields = append(fields, fmt.Sprintf("**Contact:** %s", markdownLink(o.URL, o.URL)))
+	}
+	if len(o.Lifecycle) > 0 {
+		fields = append(fields, fmt.Sprintf("**Lifecycle:** %s", markdownText(o.Lifecycle)))
+	}
+
+	var buf bytes.Buffer
+	if len(fields) > 0 {
+		buf.WriteString(strings.Join(fields, " · "))
+		buf.WriteString("\n\n")
+	}
+	// Deprecated APIs get a notice with their deprecations instead
+	if o.Lifecycle == LifecycleExperimental {
+		buf.WriteString("{{% notice info %}}\nThis API is experimental and can still change in incompatible ways.\n{{% /notice %}}\n\n")
+	}
+	return buf.String()
+}
+
+// slackChannel renders a Slack channel, linking it when it is a URL
+func slackChannel(slack string) string {
+	if strings.HasPrefix(slack, "http://") || strings.HasPrefix(slack, "https://") {
+		return markdownLink(slack, slack)
+	}
+	if !strings.HasPrefix(slack, "#") {
+		slack = "#" + slack
+	}
+	return markdownText(slack)
+}
+
+// markdownEscaper escapes the characters of a value that have a meaning in Markdown, in the HTML that Blackfriday
+// passes through, in the cells of a table or in the shortcodes of Hugo
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", "&lt;", ">", "&gt;", "&", "&amp;", `"`, "&quot;", "|", "&#124;", "{", "&#123;", "}", "&#125;",
+	"\n", " ", "\r", " ",
+)
+
+// linkEscaper escapes the characters of a URL that would end the target of a Markdown link or a cell of a table
+var linkEscaper = strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29", "<", "%3C", ">", "%3E", `"`, "%22", "|", "%7C",
+	"{", "%7B", "}", "%7D", "\n", "", "\r", "")
+
+// markdownText escapes a value from the annotations, resources or documents of an API, so it can't add HTML (like a
+// script), links or cells of a table to a page
+func markdownText(value string) string {
+	return markdownEscaper.Replace(value)
+}
+
+// markdownLink renders a link with the text to the target. Only http(s) and mailto URLs are linked, so a value can't
+// add a javascript: link, other targets are rendered as text.
+func markdownLink(text string, target string) string {
+	u, err := url.Parse(target)
+	if err != nil {
+		return markdownText(text)
+	}
+	web := (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0
+	mail := u.Scheme == "mailto" && len(u.Opaque) > 0
+	if !web && !mail {
+		return markdownText(text)
+	}
+	return fmt.Sprintf("[%s](%s)", markdownText(text), linkEscaper.Replace(target))
+}
+
+// storeRoot returns the relative path from the page of an API to the section of the Hugo store, which is two levels
+// below the root of the site (the language and the store itself)
+func storeRoot(group string) string {
+	return strings.TrimPrefix(relativeRoot(group), "../../")
+}
+
+// teamSlug returns the name of the page of a team, which only has lowercase letters, digits and dashes (spaces are
+// replaced by dashes like in the page of a term), so a team can't write its page outside of the directory of the
+// teams. Teams with other characters in their name get a part of the hash of the name, so they don't share a page with
+// a team whose name only differs in those characters.
+func teamSlug(team string) string {
+	var slug []rune
+	dropped := false
+	for _, r := range strings.ToLower(team) {
+		switch {
+		case r == ' ':
+			slug = append(slug, '-')
+		case r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-':
+			slug = append(slug, r)
+		default:
+			dropped = true
+		}
+	}
+	if !dropped && len(slug) > 0 {
+		return string(slug)
+	}
+	sum := sha256.Sum256([]byte(team))
+	return strings.TrimPrefix(fmt.Sprintf("%s-%x", string(slug), sum[:4]), "-")
+}
+
+// WriteTeamsToDisk writes a Markdown file for every team that lists the APIs of the team, and a section that lists
+// the teams, to the section of the listings in Hugo. The pages of teams that no longer have APIs are removed.
+func WriteTeamsToDisk(apis []OwnedAPI, hugoStore string) error {
+	teams := make(map[string][]OwnedAPI)
+	for _, api := range apis {
+		if team := api.Ownership.TeamName(); len(team) > 0 {
+			teams[team] = append(teams[team], api)
+		}
+	}
+	var names []string
+	for team := range teams {
+		names = append(names, team)
+	}
+	sort.Strings(names)
+
+	// The section lists the teams with the number of APIs they own
+	var buf bytes.Buffer
+	buf.WriteString("| Team | APIs |\n|---|---|\n")
+	for _, team := range names {
+		buf.WriteString(fmt.Sprintf("| [%s](%s/) | %d |\n", markdownText(team), teamSlug(team), len(teams[team])))
+	}
+	dir := filepath.Join(hugoStore, ListingsDir, teamsDir)
+	if err := writeTeamPage(dir, "_index.md", "Teams", 20, buf.String()); err != nil {
+		return err
+	}
+
+	written := map[string]bool{"_index.md": true}
+	for _, team := range names {
+		owned := teams[team]
+		sort.Slice(owned, func(i, j int) bool { return owned[i].Name < owned[j].Name })
+
+		var buf bytes.Buffer
+		buf.WriteString("| API | Namespace | Owner | Contact | Lifecycle |\n|---|---|---|---|---|\n")
+		for _, api := range owned {
+			link := path.Join("../../..", api.Group, strings.Replace(strings.ToLower(api.Page), " ", "-", -1)) + "/"
+			contact := markdownText(api.Ownership.Email)
+			if len(api.Ownership.Slack) > 0 {
+				contact = slackChannel(api.Ownership.Slack)
+			}
+			buf.WriteString(fmt.Sprintf("| [%s](%s) | %s | %s | %s | %s |\n", markdownText(api.Name), link,
+				markdownText(namespaceName(api.Namespace)), markdownText(api.Ownership.Owner), contact, markdownText(api.Ownership.Lifecycle)))
+		}
+
+		filename := teamSlug(team) + ".md"
+		if err := writeTeamPage(dir, filename, team, 100, buf.String()); err != nil {
+			return err
+		}
+		written[filename] = true
+	}
+
+	// Remove the pages of teams that no longer have APIs
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("error while reading directory: %s", err.Error())
+	}
+	for _, file := range files {
+		if !written[file.Name()] {
+			os.Remove(filepath.Join(dir, file.Name()))
+		}
+	}
+
+	return nil
+}
+
+// writeTeamPage renders a page with the title and body and writes it to the file in the directory
+func writeTeamPage(dir string, filename string, title string, weight int, body string) error {
+	// The title is quoted as a JSON string (which is valid YAML), as the name of a team can contain characters with a
+	// meaning in YAML
+	quoted, _ := json.Marshal(title)
+	dataMap := make(map[string]interface{})
+	dataMap["title"] = string(quoted)
+	dataMap["weight"] = weight
+	dataMap["body"] = body
+	return writeMarkdown(teamMarkdown, dataMap, dir, filename)
+}
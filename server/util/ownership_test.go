@@ -0,0 +1,135 @@
+package util
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeContact(t *testing.T) {
+	apidoc := `{"openapi": "3.0.0", "info": {"title": "Invoices", "contact": {"name": "Jane", "email": "invoicing@example.com"}}}`
+
+	ownership := Ownership{Owner: "invoicing-team", Slack: "invoicing"}.MergeContact(apidoc)
+	expected := Ownership{Owner: "invoicing-team", Slack: "invoicing", Email: "invoicing@example.com"}
+	if ownership != expected {
+		t.Errorf("Expected ownership %+v, got %+v", expected, ownership)
+	}
+
+	// The front matter quotes the values, as they can contain characters with a meaning in YAML
+	if frontMatter := ownership.frontMatter(); !strings.Contains(frontMatter, "slack: \"invoicing\"\n") {
+		t.Errorf("Unexpected front matter %s", frontMatter)
+	}
+}
+
+func TestWriteTeamsToDisk(t *testing.T) {
+	store := t.TempDir()
+	dir := filepath.Join(store, ListingsDir, teamsDir)
+	os.MkdirAll(dir, 0755)
+	ioutil.WriteFile(filepath.Join(dir, "disbanded.md"), []byte("---\ntitle: disbanded\n---\n"), 0644)
+
+	apis := []OwnedAPI{
+		{Name: "Invoices", Page: "invoices", Group: "prod", Namespace: "billing", Ownership: Ownership{Team: "Invoicing", Owner: "Jane", Slack: "#invoicing", Lifecycle: LifecycleStable}},
+		{Name: "Payments", Page: "payments", Namespace: "billing", Ownership: Ownership{Owner: "Invoicing"}},
+		{Name: "Users", Page: "users"},
+	}
+	if err := WriteTeamsToDisk(apis, store); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(dir, "invoicing.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"| [Invoices](../../../prod/invoices/) | billing | Jane | #invoicing | stable |",
+		"| [Payments](../../../payments/) | billing | Invoicing |  |  |",
+	} {
+		if !strings.Contains(string(content), line) {
+			t.Errorf("Expected team page to contain %s, got:\n%s", line, content)
+		}
+	}
+
+	// Only the teams with APIs have a page
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 2 {
+		t.Errorf("Expected the section and the page of one team, got %d files", len(files))
+	}
+
+	// The name of a team can't point outside of the directory of the teams, and is quoted in the front matter
+	apis = []OwnedAPI{{Name: "Invoices", Page: "invoices", Ownership: Ownership{Team: "../leaderboard"}}}
+	if err := WriteTeamsToDisk(apis, store); err != nil {
+		t.Fatal(err)
+	}
+	slug := teamSlug("../leaderboard")
+	content, err = ioutil.ReadFile(filepath.Join(dir, slug+".md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "---\ntitle: \"../leaderboard\"\n") {
+		t.Errorf("Expected the title to be quoted, got:\n%s", content)
+	}
+	if _, err := os.Stat(filepath.Join(store, ListingsDir, "leaderboard.md")); err == nil {
+		t.Error("Expected the page of the team to stay in the directory of the teams")
+	}
+}
+
+func TestTeamSlug(t *testing.T) {
+	for team, expected := range map[string]string{
+		"Invoicing":         "invoicing",
+		"Billing and Tax":   "billing-and-tax",
+		"payments-platform": "payments-platform",
+	} {
+		if slug := teamSlug(team); slug != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, team, slug)
+		}
+	}
+
+	// Other characters are dropped, and a part of the hash keeps the names that only differ in them apart
+	for _, team := range []string{"../leaderboard", "billing/tax", "billingtax?", "日本"} {
+		slug := teamSlug(team)
+		if strings.Trim(slug, "abcdefghijklmnopqrstuvwxyz0123456789-") != "" || len(slug) == 0 {
+			t.Errorf("Unexpected slug %s for %s", slug, team)
+		}
+	}
+	if teamSlug("billing/tax") == teamSlug("billingtax?") || teamSlug("billing/tax") == "billingtax" {
+		t.Error("Expected teams that only differ in the dropped characters to have different slugs")
+	}
+}
+
+func TestOwnershipMarkdown(t *testing.T) {
+	ownership := Ownership{
+		Owner: "<script>alert(1)</script>",
+		Email: "jane@example.com",
+		Slack: "javascript:alert(1)",
+		Repo:  "javascript:alert(1)",
+		URL:   "https://example.com/wiki (invoices)",
+	}
+	markdown := ownershipMarkdown(ownership, "")
+	for _, expected := range []string{
+		"**Owner:** &lt;script&gt;alert(1)&lt;/script&gt;",
+		"**Email:** [jane@example.com](mailto:jane@example.com)",
+		"**Slack:** #javascript:alert(1)",
+		"**Repository:** javascript:alert(1)",
+		"**Contact:** [https://example.com/wiki (invoices)](https://example.com/wiki%20%28invoices%29)",
+	} {
+		if !strings.Contains(markdown, expected) {
+			t.Errorf("Expected the ownership to contain %s, got:\n%s", expected, markdown)
+		}
+	}
+	if strings.Contains(markdown, "<script>") || strings.Contains(markdown, "](javascript:") {
+		t.Errorf("Expected the ownership to be escaped, got:\n%s", markdown)
+	}
+
+	// A value can't add cells to the tables of the teams
+	store := t.TempDir()
+	apis := []OwnedAPI{{Name: "Invoices", Page: "invoices", Ownership: Ownership{Team: "Invoicing", Owner: "Jane | <b>admin</b>"}}}
+	if err := WriteTeamsToDisk(apis, store); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadFile(filepath.Join(store, ListingsDir, teamsDir, "invoicing.md"))
+	if line := "| [Invoices](../../../invoices/) | - | Jane &#124; &lt;b&gt;admin&lt;/b&gt; |  |  |"; !strings.Contains(string(content), line) {
+		t.Errorf("Expected the team page to contain %s, got:\n%s", line, content)
+	}
+}
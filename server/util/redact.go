@@ -0,0 +1,226 @@
+// Package util implements utility methods
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+// The text that replaces redacted values
+const redacted = "REDACTED"
+
+// The regular expressions of the built-in patterns, by name
+var builtinPatterns = map[string]string{
+	// Bearer tokens, JSON Web Tokens, AWS access keys and private keys
+	"secrets": `(?i)bearer\s+[a-z0-9._~+/-]+=*|eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*|AKIA[0-9A-Z]{16}|-----BEGIN [A-Z ]*PRIVATE KEY-----`,
+	"emails":  `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ips":     `\b(?:(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1?[0-9]?[0-9])\b`,
+}
+
+// The names of properties whose string values are secrets, which are redacted with the secrets pattern
+var secretKeys = regexp.MustCompile(`(?i)^(password|passwd|secret|client_?secret|token|access_?token|refresh_?token|api_?key|authorization)$`)
+
+// RedactionRules configures what is redacted from OpenAPI documents before they are published
+type RedactionRules struct {
+	// The locations of values that are removed, with the keys separated by dots and * matching any key (like
+	// info.contact.email or paths.*.*.x-amazon-apigateway-integration)
+	Paths []string `json:"paths,omitempty"`
+	// The built-in patterns (secrets, emails and ips) whose matches are redacted from all strings
+	Builtins []string `json:"builtins,omitempty"`
+	// Regular expressions whose matches are redacted from all strings, by name
+	Patterns map[string]string `json:"patterns,omitempty"`
+	// The extensions that are removed wherever they appear, a trailing * matches any extension with that prefix
+	Extensions []string `json:"extensions,omitempty"`
+
+	// The compiled patterns, by name
+	compiled map[string]*regexp.Regexp
+}
+
+// Redaction is a place where something was redacted from an OpenAPI document
+type Redaction struct {
+	// The rule that redacted it, which is path, extension or the name of a pattern
+	Rule string `json:"rule"`
+	// The location in the document (like info.contact.email)
+	Location string `json:"location"`
+}
+
+// LoadRedactionRules reads the redaction rules from a JSON or YAML file
+func LoadRedactionRules(filename string) (*RedactionRules, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading redaction rules: %s", err.Error())
+	}
+
+	var rules RedactionRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("error while parsing redaction rules: %s", err.Error())
+	}
+
+	// Compile the patterns, so an invalid one doesn't go unnoticed
+	rules.compiled = make(map[string]*regexp.Regexp)
+	for _, name := range rules.Builtins {
+		pattern, ok := builtinPatterns[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown built-in pattern %s in redaction rules", name)
+		}
+		rules.compiled[name] = regexp.MustCompile(pattern)
+	}
+	for name, pattern := range rules.Patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("error while compiling pattern %s in redaction rules: %s", name, err.Error())
+		}
+		rules.compiled[name] = compiled
+	}
+
+	return &rules, nil
+}
+
+// Redact applies the redaction rules to a JSON document and returns the redacted document together with what was
+// redacted. The document is returned unchanged when there are no rules or when it isn't JSON.
+func Redact(apidoc string, rules *RedactionRules) (string, []Redaction, error) {
+	if rules == nil {
+		return apidoc, nil, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return apidoc, nil, nil
+	}
+
+	var redactions []Redaction
+	doc = rules.redact(doc, nil, "", &redactions)
+	if len(redactions) == 0 {
+		return apidoc, nil, nil
+	}
+
+	apibytes, err := json.Marshal(doc)
+	if err != nil {
+		return "", nil, fmt.Errorf("error while marshaling redacted document: %s", err.Error())
+	}
+	return string(apibytes), redactions, nil
+}
+
+// RedactHost applies the redaction rules to the host that is written into Swagger 2.0 documents when they are written
+// to disk, which happens after the documents themselves were redacted. An empty host is returned when the rules remove
+// the host of documents.
+func RedactHost(host string, rules *RedactionRules) (string, error) {
+	doc, err := json.Marshal(map[string]string{"host": host})
+	if err != nil {
+		return "", fmt.Errorf("error while marshaling host: %s", err.Error())
+	}
+	redacted, _, err := Redact(string(doc), rules)
+	if err != nil {
+		return "", err
+	}
+
+	var result map[string]string
+	if err := json.Unmarshal([]byte(redacted), &result); err != nil {
+		return "", fmt.Errorf("error while unmarshaling redacted host: %s", err.Error())
+	}
+	return result["host"], nil
+}
+
+// redact applies the rules to a node of the document at the location, where key is the key of the node in its
+// parent object
+func (r *RedactionRules) redact(node interface{}, location []string, key string, redactions *[]Redaction) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		for _, k := range sortedKeys(n) {
+			child := append(append([]string{}, location...), k)
+			if r.isExtension(k) {
+				delete(n, k)
+				*redactions = append(*redactions, Redaction{Rule: "extension", Location: strings.Join(child, ".")})
+				continue
+			}
+			if r.isPath(child) {
+				delete(n, k)
+				*redactions = append(*redactions, Redaction{Rule: "path", Location: strings.Join(child, ".")})
+				continue
+			}
+			// References point within the document, so they are never redacted
+			if k == "$ref" {
+				continue
+			}
+			n[k] = r.redact(n[k], child, k, redactions)
+		}
+		return n
+	case []interface{}:
+		var items []interface{}
+		for i, item := range n {
+			child := append(append([]string{}, location...), strconv.Itoa(i))
+			if r.isPath(child) {
+				*redactions = append(*redactions, Redaction{Rule: "path", Location: strings.Join(child, ".")})
+				continue
+			}
+			items = append(items, r.redact(item, child, key, redactions))
+		}
+		if items == nil {
+			items = []interface{}{}
+		}
+		return items
+	case string:
+		if _, ok := r.compiled["secrets"]; ok && secretKeys.MatchString(key) && len(n) > 0 {
+			*redactions = append(*redactions, Redaction{Rule: "secrets", Location: strings.Join(location, ".")})
+			return redacted
+		}
+		for _, name := range sortedPatterns(r.compiled) {
+			if pattern := r.compiled[name]; pattern.MatchString(n) {
+				n = pattern.ReplaceAllString(n, redacted)
+				*redactions = append(*redactions, Redaction{Rule: name, Location: strings.Join(location, ".")})
+			}
+		}
+		return n
+	default:
+		return node
+	}
+}
+
+// isExtension checks whether the key is one of the extensions that are removed
+func (r *RedactionRules) isExtension(key string) bool {
+	if !strings.HasPrefix(key, "x-") {
+		return false
+	}
+	for _, extension := range r.Extensions {
+		if extension == key || (strings.HasSuffix(extension, "*") && strings.HasPrefix(key, strings.TrimSuffix(extension, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPath checks whether the location matches one of the paths that are removed
+func (r *RedactionRules) isPath(location []string) bool {
+	for _, p := range r.Paths {
+		segments := strings.Split(p, ".")
+		if len(segments) != len(location) {
+			continue
+		}
+		matched := true
+		for i, segment := range segments {
+			if segment != "*" && segment != location[i] {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedPatterns returns the names of the patterns in alphabetical order, so redacting is deterministic
+func sortedPatterns(patterns map[string]*regexp.Regexp) []string {
+	m := make(map[string]interface{}, len(patterns))
+	for name := range patterns {
+		m[name] = nil
+	}
+	return sortedKeys(m)
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRedact(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "redaction.yml")
+	ioutil.WriteFile(filename, []byte("paths:\n  - info.contact.email\n  - servers.1\nbuiltins: [secrets, emails, ips]\npatterns:\n  hosts: '[a-z-]+\\.svc\\.cluster\\.local'\nextensions:\n  - x-internal-*\n"), 0644)
+	rules, err := LoadRedactionRules(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apidoc := `{
+		"openapi": "3.0.0",
+		"info": {"title": "Invoices", "contact": {"name": "Billing", "email": "billing@example.com"}, "x-internal-owner": "jane"},
+		"servers": [{"url": "http://invoices.billing.svc.cluster.local"}, {"url": "http://10.0.12.7:8080"}],
+		"paths": {
+			"/login": {
+				"post": {
+					"description": "Contact support@example.com when the login fails",
+					"responses": {"200": {"description": "OK", "content": {"application/json": {"example": {"token": "abc123", "user": "jane"}}}}}
+				}
+			}
+		}
+	}`
+
+	redacted, redactions, err := Redact(apidoc, rules)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, value := range []string{"billing@example.com", "support@example.com", "x-internal-owner", "10.0.12.7", "abc123", "svc.cluster.local"} {
+		if strings.Contains(redacted, value) {
+			t.Errorf("Expected %s to be redacted, got %s", value, redacted)
+		}
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(redacted), &doc)
+	if servers := doc["servers"].([]interface{}); len(servers) != 1 {
+		t.Errorf("Expected the second server to be removed, got %v", servers)
+	}
+
+	expected := []Redaction{
+		{Rule: "path", Location: "info.contact.email"},
+		{Rule: "extension", Location: "info.x-internal-owner"},
+		{Rule: "emails", Location: "paths./login.post.description"},
+		{Rule: "secrets", Location: "paths./login.post.responses.200.content.application/json.example.token"},
+		{Rule: "hosts", Location: "servers.0.url"},
+		{Rule: "path", Location: "servers.1"},
+	}
+	if !reflect.DeepEqual(redactions, expected) {
+		t.Errorf("Expected redactions %v, got %v", expected, redactions)
+	}
+
+	// Without rules the document is published as it is
+	if unchanged, redactions, _ := Redact(apidoc, nil); unchanged != apidoc || redactions != nil {
+		t.Errorf("Expected the document to be unchanged without rules")
+	}
+}
@@ -0,0 +1,243 @@
+// Package util implements utility methods
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// A template for the Markdown file of the leaderboard in Hugo
+const leaderboardMarkdown = `---
+title: Leaderboard
+weight: 10
+---
+
+{{.body}}`
+
+// Score is the quality of an OpenAPI document, every part and the total is a percentage between 0 and 100
+type Score struct {
+	// The average of the other parts
+	Total int `json:"total"`
+	// How many operations and parameters have a description
+	Documentation int `json:"documentation"`
+	// How many operations have examples of their successful responses
+	Examples int `json:"examples"`
+	// How many schemas and properties have both a type and a description
+	Schemas int `json:"schemas"`
+	// How many operations have no lint results with severity error or warning
+	Lint int `json:"lint"`
+}
+
+// ScoredAPI is an API on the leaderboard
+type ScoredAPI struct {
+	// The name of the API
+	Name string
+	// The name of the page of the API
+	Page string
+	// The group (like a cluster) in which the page of the API is written
+	Group string
+	// The namespace of the API
+	Namespace string
+	// The quality score of the API
+	Score Score
+}
+
+// ScoreAPI calculates the quality score of a Swagger 2.0 or OpenAPI 3 document from the document itself and the
+// results of linting it. A part without anything to measure (like the schemas of an API without schemas) scores 100.
+func ScoreAPI(apidoc string, lint []LintResult) Score {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return Score{}
+	}
+
+	// The locations of the lint results that count against an operation
+	var findings []string
+	for _, result := range lint {
+		if result.Severity == SeverityError || result.Severity == SeverityWarning {
+			findings = append(findings, result.Location)
+		}
+	}
+
+	var documented, documentable, withExamples, passing, operations int
+	paths, _ := doc["paths"].(map[string]interface{})
+	for p, i := range paths {
+		item, _ := i.(map[string]interface{})
+		pathParameters, _ := item["parameters"].([]interface{})
+		for _, method := range methods {
+			operation, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			location := fmt.Sprintf("paths.%s.%s", p, method)
+			operations++
+
+			// Count the operation and each of its parameters
+			documentable++
+			summary, _ := operation["summary"].(string)
+			description, _ := operation["description"].(string)
+			if len(summary) > 0 || len(description) > 0 {
+				documented++
+			}
+			parameters, _ := operation["parameters"].([]interface{})
+			for _, p := range append(pathParameters, parameters...) {
+				parameter, _ := p.(map[string]interface{})
+				// Shared parameters are counted with the document they are defined in
+				if _, ok := parameter["$ref"]; ok {
+					continue
+				}
+				documentable++
+				if description, _ := parameter["description"].(string); len(description) > 0 {
+					documented++
+				}
+			}
+
+			responses, _ := operation["responses"].(map[string]interface{})
+			if hasExamples(responses) {
+				withExamples++
+			}
+
+			// Results on the path (like kebab-case-paths) count against all of its operations
+			passed := true
+			for _, finding := range findings {
+				if finding == location || strings.HasPrefix(location, finding+".") {
+					passed = false
+					break
+				}
+			}
+			if passed {
+				passing++
+			}
+		}
+	}
+
+	// Swagger 2.0 keeps its schemas in definitions and OpenAPI 3 in components
+	schemas, ok := doc["definitions"].(map[string]interface{})
+	if !ok {
+		components, _ := doc["components"].(map[string]interface{})
+		schemas, _ = components["schemas"].(map[string]interface{})
+	}
+	var complete, described int
+	for _, s := range schemas {
+		schema, _ := s.(map[string]interface{})
+		described++
+		if hasType(schema) && hasDescription(schema) {
+			complete++
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		for _, p := range properties {
+			property, _ := p.(map[string]interface{})
+			described++
+			// A reference can't have a description of its own
+			if _, ok := property["$ref"]; ok || (hasType(property) && hasDescription(property)) {
+				complete++
+			}
+		}
+	}
+
+	score := Score{
+		Documentation: percentage(documented, documentable),
+		Examples:      percentage(withExamples, operations),
+		Schemas:       percentage(complete, described),
+		Lint:          percentage(passing, operations),
+	}
+	score.Total = int(math.Round(float64(score.Documentation+score.Examples+score.Schemas+score.Lint) / 4))
+	return score
+}
+
+// hasType checks whether a schema declares its type, either directly or by composing other schemas
+func hasType(schema map[string]interface{}) bool {
+	for _, key := range []string{"type", "$ref", "allOf", "oneOf", "anyOf", "enum"} {
+		if _, ok := schema[key]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// hasDescription checks whether a schema has a description
+func hasDescription(schema map[string]interface{}) bool {
+	description, _ := schema["description"].(string)
+	return len(description) > 0
+}
+
+// percentage returns the part of the total as a rounded percentage, which is 100 when there is nothing to count
+func percentage(part int, total int) int {
+	if total == 0 {
+		return 100
+	}
+	return int(math.Round(float64(part) * 100 / float64(total)))
+}
+
+// scoreMarkdown renders the score of an API as a table using the expand shortcode of the theme
+func scoreMarkdown(score Score) string {
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("{{%% expand \"Quality score: %d/100\" %%}}\n", score.Total))
+	buf.WriteString("| Documentation | Examples | Schemas | Lint |\n|---|---|---|---|\n")
+	buf.WriteString(fmt.Sprintf("| %d | %d | %d | %d |\n", score.Documentation, score.Examples, score.Schemas, score.Lint))
+	buf.WriteString("{{% /expand %}}\n\n")
+	return buf.String()
+}
+
+// WriteLeaderboardToDisk writes the Markdown file of the leaderboard to the section of the listings in Hugo, which
+// ranks all APIs by their score and all namespaces by the average score of their APIs
+func WriteLeaderboardToDisk(apis []ScoredAPI, hugoStore string) error {
+	sort.SliceStable(apis, func(i, j int) bool {
+		if apis[i].Score.Total != apis[j].Score.Total {
+			return apis[i].Score.Total > apis[j].Score.Total
+		}
+		return apis[i].Name < apis[j].Name
+	})
+
+	var buf bytes.Buffer
+	buf.WriteString("## APIs\n\n")
+	buf.WriteString("| Rank | API | Namespace | Score | Documentation | Examples | Schemas | Lint |\n|---|---|---|---|---|---|---|---|\n")
+	totals := make(map[string][]int)
+	for i, api := range apis {
+		link := path.Join("../..", api.Group, strings.Replace(strings.ToLower(api.Page), " ", "-", -1)) + "/"
+		buf.WriteString(fmt.Sprintf("| %d | [%s](%s) | %s | **%d** | %d | %d | %d | %d |\n", i+1, api.Name, link, namespaceName(api.Namespace),
+			api.Score.Total, api.Score.Documentation, api.Score.Examples, api.Score.Schemas, api.Score.Lint))
+		totals[api.Namespace] = append(totals[api.Namespace], api.Score.Total)
+	}
+
+	// Rank the namespaces by the average score of their APIs
+	type namespaceScore struct {
+		namespace string
+		average   int
+		count     int
+	}
+	var namespaces []namespaceScore
+	for namespace, scores := range totals {
+		sum := 0
+		for _, score := range scores {
+			sum += score
+		}
+		namespaces = append(namespaces, namespaceScore{namespace: namespace, average: int(math.Round(float64(sum) / float64(len(scores)))), count: len(scores)})
+	}
+	sort.Slice(namespaces, func(i, j int) bool {
+		if namespaces[i].average != namespaces[j].average {
+			return namespaces[i].average > namespaces[j].average
+		}
+		return namespaces[i].namespace < namespaces[j].namespace
+	})
+	buf.WriteString("\n## Namespaces\n\n")
+	buf.WriteString("| Rank | Namespace | APIs | Average score |\n|---|---|---|---|\n")
+	for i, namespace := range namespaces {
+		buf.WriteString(fmt.Sprintf("| %d | %s | %d | **%d** |\n", i+1, namespaceName(namespace.namespace), namespace.count, namespace.average))
+	}
+
+	return writeMarkdown(leaderboardMarkdown, map[string]interface{}{"body": buf.String()}, filepath.Join(hugoStore, ListingsDir), "leaderboard.md")
+}
+
+// namespaceName returns the namespace to show, as APIs from sources without namespaces have none
+func namespaceName(namespace string) string {
+	if len(namespace) == 0 {
+		return "-"
+	}
+	return namespace
+}
@@ -0,0 +1,67 @@
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScoreAPI(t *testing.T) {
+	swagger := `{
+		"swagger": "2.0",
+		"paths": {
+			"/invoices/{id}": {
+				"parameters": [{"name": "id", "in": "path", "type": "string"}],
+				"get": {"summary": "Get an invoice", "responses": {"200": {"description": "OK", "schema": {"$ref": "#/definitions/Invoice"}, "examples": {"application/json": {}}}}},
+				"delete": {"responses": {"204": {"description": "Deleted"}}}
+			}
+		},
+		"definitions": {
+			"Invoice": {"type": "object", "description": "An invoice", "properties": {"id": {"type": "string"}, "amount": {"type": "number", "description": "The amount"}}}
+		}
+	}`
+	lint := []LintResult{
+		{Rule: "operation-description", Severity: SeverityWarning, Location: "paths./invoices/{id}.delete"},
+		{Rule: "examples", Severity: SeverityInfo, Location: "paths./invoices/{id}.get"},
+	}
+
+	score := ScoreAPI(swagger, lint)
+	// 1 of 2 operations and none of the parameters (the path parameter counts for both operations) are documented
+	expected := Score{Total: 61, Documentation: 25, Examples: 100, Schemas: 67, Lint: 50}
+	if score != expected {
+		t.Errorf("Expected score %+v, got %+v", expected, score)
+	}
+
+	if score := ScoreAPI("not json", nil); score != (Score{}) {
+		t.Errorf("Expected an empty score for an invalid document, got %+v", score)
+	}
+}
+
+func TestWriteLeaderboardToDisk(t *testing.T) {
+	store := t.TempDir()
+
+	apis := []ScoredAPI{
+		{Name: "Invoices", Page: "invoices", Group: "prod", Namespace: "billing", Score: Score{Total: 60}},
+		{Name: "Payments", Page: "payments", Namespace: "billing", Score: Score{Total: 90}},
+		{Name: "Users", Page: "users", Namespace: "accounts", Score: Score{Total: 80}},
+	}
+	if err := WriteLeaderboardToDisk(apis, store); err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(store, ListingsDir, "leaderboard.md"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"| 1 | [Payments](../../payments/) | billing | **90** |",
+		"| 3 | [Invoices](../../prod/invoices/) | billing | **60** |",
+		"| 1 | accounts | 1 | **80** |",
+		"| 2 | billing | 2 | **75** |",
+	} {
+		if !strings.Contains(string(content), line) {
+			t.Errorf("Expected leaderboard to contain %s, got:\n%s", line, content)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Package util implements utility methods
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// SetPublicURL rewrites the servers of an OpenAPI document to the public URL (scheme, host and path prefix) through
+// which the API is exposed. For Swagger 2.0 documents the host, basePath and schemes are updated and for OpenAPI 3
+// documents the servers are replaced. When the basePath of the document already starts with the path prefix, the
+// service is expected to serve the full path and the basePath is kept as is.
+func SetPublicURL(apidoc string, publicURL string) (string, error) {
+	public, err := url.Parse(publicURL)
+	if err != nil {
+		return "", fmt.Errorf("error while parsing public URL: %s", err.Error())
+	}
+
+	var swagger map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &swagger); err != nil {
+		return "", fmt.Errorf("error while unmarshaling JSON: %s", err.Error())
+	}
+
+	if _, ok := swagger["openapi"]; ok {
+		// OpenAPI 3 uses a list of servers, of which the path of the first one is kept
+		serverPath := ""
+		if servers, ok := swagger["servers"].([]interface{}); ok && len(servers) > 0 {
+			if server, ok := servers[0].(map[string]interface{}); ok {
+				if u, ok := server["url"].(string); ok {
+					if parsed, err := url.Parse(u); err == nil {
+						serverPath = parsed.Path
+					}
+				}
+			}
+		}
+		swagger["servers"] = []interface{}{
+			map[string]interface{}{"url": fmt.Sprintf("%s://%s%s", public.Scheme, public.Host, joinPrefix(public.Path, serverPath))},
+		}
+	} else {
+		// Swagger 2.0 splits the URL into host, basePath and schemes
+		basePath, _ := swagger["basePath"].(string)
+		swagger["host"] = public.Host
+		swagger["basePath"] = joinPrefix(public.Path, basePath)
+		swagger["schemes"] = []interface{}{public.Scheme}
+	}
+
+	apibytes, err := json.Marshal(swagger)
+	if err != nil {
+		return "", fmt.Errorf("error while marshaling API: %s", err.Error())
+	}
+
+	return string(apibytes), nil
+}
+
+// joinPrefix adds the path prefix to the basePath, unless the basePath already starts with the prefix
+func joinPrefix(prefix string, basePath string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if len(prefix) == 0 || basePath == prefix || strings.HasPrefix(basePath, prefix+"/") {
+		if len(basePath) == 0 {
+			return "/"
+		}
+		return basePath
+	}
+	return prefix + "/" + strings.TrimPrefix(basePath, "/")
+}
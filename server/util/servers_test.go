@@ -0,0 +1,40 @@
+package util
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSetPublicURL(t *testing.T) {
+	swagger := `{"swagger": "2.0", "host": "10.0.0.1:80", "basePath": "/api", "schemes": ["http"]}`
+	apidoc, err := SetPublicURL(swagger, "https://api.example.com/invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc map[string]interface{}
+	json.Unmarshal([]byte(apidoc), &doc)
+	if doc["host"] != "api.example.com" || doc["basePath"] != "/invoices/api" || doc["schemes"].([]interface{})[0] != "https" {
+		t.Fatalf("Unexpected Swagger 2.0 document %s", apidoc)
+	}
+
+	// The service serves the full path, so the basePath should be kept
+	swagger = `{"swagger": "2.0", "host": "10.0.0.1:80", "basePath": "/invoices/api"}`
+	apidoc, _ = SetPublicURL(swagger, "https://api.example.com/invoices")
+	json.Unmarshal([]byte(apidoc), &doc)
+	if doc["basePath"] != "/invoices/api" {
+		t.Fatalf("Unexpected basePath %s", doc["basePath"])
+	}
+
+	openapi := `{"openapi": "3.0.0", "servers": [{"url": "http://localhost:8080/v1"}]}`
+	apidoc, err = SetPublicURL(openapi, "https://api.example.com/invoices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = make(map[string]interface{})
+	json.Unmarshal([]byte(apidoc), &doc)
+	server := doc["servers"].([]interface{})[0].(map[string]interface{})
+	if server["url"] != "https://api.example.com/invoices/v1" {
+		t.Fatalf("Unexpected server %s", server["url"])
+	}
+}
@@ -0,0 +1,141 @@
+// Package util implements utility methods
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Taxonomies are the terms by which an API can be browsed in Hugo, by taxonomy
+type Taxonomies struct {
+	// The tags of the API, from the tags in its OpenAPI document and the tags that were declared for it
+	Tags []string
+	// The categories (domains) of the API
+	Categories []string
+	// The labels of the object the API was discovered from, as key=value
+	Labels []string
+}
+
+// NewTaxonomies returns the taxonomies of an API from the tags in its OpenAPI document (both the tags of the document
+// and the tags of its operations), the declared tags and categories, and the labels. Hugo treats terms case-insensitively,
+// so the terms are lowercased. The slashes of label keys (like app.kubernetes.io/name) are replaced by dashes, as Hugo
+// would turn them into directories.
+func NewTaxonomies(apidoc string, tags []string, categories []string, labels map[string]string) Taxonomies {
+	var doc struct {
+		Tags []struct {
+			Name string `json:"name"`
+		} `json:"tags"`
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	json.Unmarshal([]byte(apidoc), &doc)
+
+	for _, tag := range doc.Tags {
+		tags = append(tags, tag.Name)
+	}
+	for _, item := range doc.Paths {
+		for _, method := range methods {
+			var operation struct {
+				Tags []string `json:"tags"`
+			}
+			if raw, ok := item[method]; ok && json.Unmarshal(raw, &operation) == nil {
+				tags = append(tags, operation.Tags...)
+			}
+		}
+	}
+
+	var terms []string
+	for key, value := range labels {
+		terms = append(terms, fmt.Sprintf("%s=%s", strings.Replace(key, "/", "-", -1), value))
+	}
+
+	return Taxonomies{Tags: normalizeTerms(tags), Categories: normalizeTerms(categories), Labels: normalizeTerms(terms)}
+}
+
+// normalizeTerms lowercases and trims the terms, and returns them sorted without duplicates
+func normalizeTerms(terms []string) []string {
+	seen := make(map[string]bool)
+	var normalized []string
+	for _, term := range terms {
+		term = strings.ToLower(strings.TrimSpace(term))
+		if len(term) > 0 && !seen[term] {
+			seen[term] = true
+			normalized = append(normalized, term)
+		}
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+// SplitTerms splits a comma separated list of terms, like the value of an annotation
+func SplitTerms(value string) []string {
+	if len(strings.TrimSpace(value)) == 0 {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// frontMatter renders the taxonomies as YAML for the front matter of the page of an API, as lists of JSON strings
+// (which are valid YAML)
+func (t Taxonomies) frontMatter() string {
+	var buf bytes.Buffer
+	for _, taxonomy := range []struct {
+		name  string
+		terms []string
+	}{
+		{"tags", t.Tags},
+		{"categories", t.Categories},
+		{"labels", t.Labels},
+	} {
+		if len(taxonomy.terms) > 0 {
+			terms, _ := json.Marshal(taxonomy.terms)
+			buf.WriteString(fmt.Sprintf("%s: %s\n", taxonomy.name, terms))
+		}
+	}
+	return buf.String()
+}
+
+// taxonomiesMarkdown renders the terms of an API as links to the pages that list the APIs with the same term
+func taxonomiesMarkdown(t Taxonomies, group string) string {
+	// The taxonomies are at the root of the language of the site, one level above the Hugo store
+	root := "../" + storeRoot(group)
+
+	var buf bytes.Buffer
+	for _, taxonomy := range []struct {
+		title string
+		name  string
+		terms []string
+	}{
+		{"Categories", "categories", t.Categories},
+		{"Tags", "tags", t.Tags},
+		{"Labels", "labels", t.Labels},
+	} {
+		if len(taxonomy.terms) == 0 {
+			continue
+		}
+		var links []string
+		for _, term := range taxonomy.terms {
+			links = append(links, fmt.Sprintf("[%s](%s%s/%s/)", term, root, taxonomy.name, termSlug(term)))
+		}
+		buf.WriteString(fmt.Sprintf("**%s:** %s\n\n", taxonomy.title, strings.Join(links, " ")))
+	}
+	return buf.String()
+}
+
+// termSlug returns the name of the page of a term the way Hugo makes it, which replaces spaces by dashes and drops
+// other characters that aren't letters, digits or one of ._-+~ (like the = of a label)
+func termSlug(term string) string {
+	var slug []rune
+	for _, r := range term {
+		switch {
+		case r == ' ':
+			slug = append(slug, '-')
+		case unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("._-+~", r):
+			slug = append(slug, r)
+		}
+	}
+	return string(slug)
+}
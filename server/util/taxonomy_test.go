@@ -0,0 +1,45 @@
+package util
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewTaxonomies(t *testing.T) {
+	apidoc := `{
+		"openapi": "3.0.0",
+		"tags": [{"name": "Invoices"}, {"name": "Reports"}],
+		"paths": {
+			"/invoices": {"get": {"tags": ["invoices", "public API"], "responses": {}}},
+			"/reports": {"get": {"tags": ["reports"], "responses": {}}}
+		}
+	}`
+	labels := map[string]string{"app.kubernetes.io/name": "invoices", "tier": "backend"}
+
+	taxonomies := NewTaxonomies(apidoc, SplitTerms("billing, invoices"), SplitTerms("Billing,finance"), labels)
+	expected := Taxonomies{
+		Tags:       []string{"billing", "invoices", "public api", "reports"},
+		Categories: []string{"billing", "finance"},
+		Labels:     []string{"app.kubernetes.io-name=invoices", "tier=backend"},
+	}
+	if !reflect.DeepEqual(taxonomies, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, taxonomies)
+	}
+
+	frontMatter := taxonomies.frontMatter()
+	if !strings.Contains(frontMatter, `tags: ["billing","invoices","public api","reports"]`) || !strings.Contains(frontMatter, `categories: ["billing","finance"]`) {
+		t.Errorf("Unexpected front matter %s", frontMatter)
+	}
+
+	// The taxonomies are at the root of the language, so from a page in a group the links go up one more level
+	markdown := taxonomiesMarkdown(taxonomies, "prod")
+	if !strings.Contains(markdown, "[public api](../../../tags/public-api/)") ||
+		!strings.Contains(markdown, "[finance](../../../categories/finance/)") || !strings.Contains(markdown, "[tier=backend](../../../labels/tierbackend/)") {
+		t.Errorf("Unexpected links %s", markdown)
+	}
+
+	if empty := NewTaxonomies("not a document", nil, nil, nil); len(empty.frontMatter()) > 0 || len(taxonomiesMarkdown(empty, "")) > 0 {
+		t.Errorf("Expected no taxonomies, got %+v", empty)
+	}
+}
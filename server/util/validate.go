@@ -0,0 +1,322 @@
+// Package util implements utility methods
+package util
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi2"
+	"github.com/getkin/kin-openapi/openapi2conv"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const (
+	// Swagger2 is the Swagger 2.0 specification
+	Swagger2 = "Swagger 2.0"
+	// OpenAPI30 is the OpenAPI 3.0 specification
+	OpenAPI30 = "OpenAPI 3.0"
+	// OpenAPI31 is the OpenAPI 3.1 specification
+	OpenAPI31 = "OpenAPI 3.1"
+)
+
+// ValidationProblem is a place where an OpenAPI document doesn't conform to its specification
+type ValidationProblem struct {
+	// The location of the problem in the document (like info.title), empty when it applies to the whole document
+	Location string `json:"location,omitempty"`
+	// A human readable explanation of the problem
+	Message string `json:"message"`
+}
+
+// String returns the problem as a single line
+func (p ValidationProblem) String() string {
+	if len(p.Location) == 0 {
+		return p.Message
+	}
+	return fmt.Sprintf("%s: %s", p.Location, p.Message)
+}
+
+// Validate checks the OpenAPI document against the Swagger 2.0, OpenAPI 3.0 or OpenAPI 3.1 specification and returns
+// the specification of the document together with the problems that were found. When the specification can't be
+// determined, the specification is empty.
+func Validate(apidoc string) (string, []ValidationProblem) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return "", []ValidationProblem{{Message: fmt.Sprintf("the document isn't a JSON object: %s", err.Error())}}
+	}
+
+	var spec string
+	var problems []ValidationProblem
+
+	// Determine the specification of the document
+	swagger, _ := doc["swagger"].(string)
+	openapi, _ := doc["openapi"].(string)
+	switch {
+	case swagger == "2.0":
+		spec = Swagger2
+	case strings.HasPrefix(openapi, "3.0."):
+		spec = OpenAPI30
+	case strings.HasPrefix(openapi, "3.1."):
+		spec = OpenAPI31
+	case len(swagger) > 0:
+		return "", []ValidationProblem{{Location: "swagger", Message: fmt.Sprintf("unsupported Swagger version %s", swagger)}}
+	case len(openapi) > 0:
+		return "", []ValidationProblem{{Location: "openapi", Message: fmt.Sprintf("unsupported OpenAPI version %s", openapi)}}
+	default:
+		return "", []ValidationProblem{{Message: "the document has neither a swagger nor an openapi field"}}
+	}
+
+	// Check the fields every specification requires, as the catalog relies on them
+	info, ok := doc["info"].(map[string]interface{})
+	if !ok {
+		problems = append(problems, ValidationProblem{Location: "info", Message: "the info object is missing"})
+	} else {
+		if title, ok := info["title"].(string); !ok || len(title) == 0 {
+			problems = append(problems, ValidationProblem{Location: "info.title", Message: "the title is missing"})
+		}
+		if version, ok := info["version"].(string); !ok || len(version) == 0 {
+			problems = append(problems, ValidationProblem{Location: "info.version", Message: "the version is missing"})
+		}
+	}
+	if _, ok := doc["paths"].(map[string]interface{}); !ok && spec != OpenAPI31 {
+		// OpenAPI 3.1 allows documents with only webhooks or components
+		problems = append(problems, ValidationProblem{Location: "paths", Message: "the paths object is missing"})
+	}
+	if len(problems) > 0 {
+		return spec, problems
+	}
+
+	// Validate the document against the rules of the specification
+	var loaded *openapi3.T
+	var err error
+	var opts []openapi3.ValidationOption
+	switch spec {
+	case Swagger2:
+		loaded, err = loadSwagger2(apidoc)
+	case OpenAPI31:
+		// JSON Schema allows the description and summary next to a reference in OpenAPI 3.1
+		opts = append(opts, openapi3.AllowExtraSiblingFields("description", "summary"))
+		fallthrough
+	default:
+		loaded, err = loadOpenAPI3(doc, spec)
+	}
+	if err != nil {
+		return spec, []ValidationProblem{{Message: err.Error()}}
+	}
+
+	return spec, validateParts(loaded, spec, opts)
+}
+
+// validator is a part of an OpenAPI document that can be validated on its own
+type validator interface {
+	Validate(ctx context.Context, opts ...openapi3.ValidationOption) error
+}
+
+// The locations of the components in a Swagger 2.0 document, as they are validated after converting to OpenAPI 3.0
+var swagger2Components = map[string]string{
+	"schemas":         "definitions",
+	"parameters":      "parameters",
+	"requestBodies":   "parameters",
+	"responses":       "responses",
+	"securitySchemes": "securityDefinitions",
+}
+
+// validateParts validates every path, component and top level object of the document on its own, so all of them are
+// reported with their location instead of only the first problem in the document. The problems are ordered by their
+// location.
+func validateParts(loaded *openapi3.T, spec string, opts []openapi3.ValidationOption) []ValidationProblem {
+	parts := make(map[string]validator)
+	if loaded.Info != nil {
+		parts["info"] = loaded.Info
+	}
+	if loaded.Paths != nil {
+		for path, item := range loaded.Paths.Map() {
+			// Paths are validated as a whole, as that checks the parameters in the path against the template
+			parts["paths."+path] = openapi3.NewPaths(openapi3.WithPath(path, item))
+		}
+	}
+	if loaded.Servers != nil {
+		parts["servers"] = loaded.Servers
+	}
+	if loaded.Tags != nil {
+		parts["tags"] = loaded.Tags
+	}
+	if loaded.Security != nil {
+		parts["security"] = loaded.Security
+	}
+	if loaded.Components != nil {
+		component := func(kind string, name string, part validator) {
+			location := "components." + kind
+			if spec == Swagger2 {
+				location = swagger2Components[kind]
+			}
+			parts[location+"."+name] = part
+		}
+		for name, part := range loaded.Components.Schemas {
+			component("schemas", name, part)
+		}
+		for name, part := range loaded.Components.Parameters {
+			component("parameters", name, part)
+		}
+		for name, part := range loaded.Components.RequestBodies {
+			component("requestBodies", name, part)
+		}
+		for name, part := range loaded.Components.Responses {
+			component("responses", name, part)
+		}
+		for name, part := range loaded.Components.Headers {
+			component("headers", name, part)
+		}
+		for name, part := range loaded.Components.SecuritySchemes {
+			component("securitySchemes", name, part)
+		}
+		for name, part := range loaded.Components.Examples {
+			component("examples", name, part)
+		}
+		for name, part := range loaded.Components.Links {
+			component("links", name, part)
+		}
+		for name, part := range loaded.Components.Callbacks {
+			component("callbacks", name, part)
+		}
+	}
+
+	locations := make([]string, 0, len(parts))
+	for location := range parts {
+		locations = append(locations, location)
+	}
+	sort.Strings(locations)
+
+	ctx := openapi3.WithValidationOptions(context.Background(), opts...)
+	var problems []ValidationProblem
+	for _, location := range locations {
+		if err := parts[location].Validate(ctx); err != nil {
+			problems = append(problems, ValidationProblem{Location: location, Message: err.Error()})
+		}
+	}
+
+	return problems
+}
+
+// loadSwagger2 loads a Swagger 2.0 document by converting it to OpenAPI 3.0, which has the same rules for the parts
+// both specifications share
+func loadSwagger2(apidoc string) (*openapi3.T, error) {
+	var doc openapi2.T
+	if err := json.Unmarshal([]byte(apidoc), &doc); err != nil {
+		return nil, err
+	}
+
+	return openapi2conv.ToV3(&doc)
+}
+
+// loadOpenAPI3 loads an OpenAPI 3.0 or 3.1 document, including the references in the document. The validator follows
+// OpenAPI 3.0, so OpenAPI 3.1 documents are first rewritten to the OpenAPI 3.0 equivalent of their schemas, without
+// the fields and JSON Schema keywords that were added in 3.1.
+func loadOpenAPI3(doc map[string]interface{}, spec string) (*openapi3.T, error) {
+	if spec == OpenAPI31 {
+		delete(doc, "webhooks")
+		delete(doc, "jsonSchemaDialect")
+		if _, ok := doc["paths"]; !ok {
+			doc["paths"] = map[string]interface{}{}
+		}
+		downgradeSchemas(doc)
+	}
+
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return openapi3.NewLoader().LoadFromData(data)
+}
+
+// The JSON Schema keywords that OpenAPI 3.1 allows in schemas, which don't exist in OpenAPI 3.0
+var jsonSchemaKeywords = []string{
+	"$schema", "$id", "$anchor", "$defs", "$comment", "const", "examples", "contains", "minContains", "maxContains",
+	"prefixItems", "if", "then", "else", "dependentSchemas", "dependentRequired", "propertyNames", "unevaluatedItems",
+	"unevaluatedProperties", "contentEncoding", "contentMediaType", "contentSchema",
+}
+
+// downgradeSchemas rewrites the schemas in a part of an OpenAPI 3.1 document to OpenAPI 3.0. Examples hold data
+// instead of schemas, so they are left alone.
+func downgradeSchemas(value interface{}) {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		for key, child := range value {
+			switch key {
+			case "example", "examples":
+			case "schema":
+				downgradeSchema(child)
+			case "schemas":
+				if schemas, ok := child.(map[string]interface{}); ok {
+					for _, schema := range schemas {
+						downgradeSchema(schema)
+					}
+				}
+			default:
+				downgradeSchemas(child)
+			}
+		}
+	case []interface{}:
+		for _, child := range value {
+			downgradeSchemas(child)
+		}
+	}
+}
+
+// downgradeSchema rewrites an OpenAPI 3.1 schema and its subschemas to OpenAPI 3.0. The null type becomes nullable and
+// numeric exclusive bounds become a bound that is marked exclusive.
+func downgradeSchema(value interface{}) {
+	schema, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	for _, keyword := range jsonSchemaKeywords {
+		delete(schema, keyword)
+	}
+	if types, ok := schema["type"].([]interface{}); ok {
+		var kept []interface{}
+		for _, t := range types {
+			if t == "null" {
+				schema["nullable"] = true
+			} else {
+				kept = append(kept, t)
+			}
+		}
+		if len(kept) == 1 {
+			schema["type"] = kept[0]
+		} else if len(kept) == 0 {
+			delete(schema, "type")
+		} else {
+			schema["type"] = kept
+		}
+	}
+	for keyword, bound := range map[string]string{"exclusiveMinimum": "minimum", "exclusiveMaximum": "maximum"} {
+		if number, ok := schema[keyword].(float64); ok {
+			schema[bound] = number
+			schema[keyword] = true
+		}
+	}
+
+	// Continue with the subschemas
+	for _, keyword := range []string{"properties", "patternProperties"} {
+		if properties, ok := schema[keyword].(map[string]interface{}); ok {
+			for _, property := range properties {
+				downgradeSchema(property)
+			}
+		}
+	}
+	for _, keyword := range []string{"allOf", "anyOf", "oneOf"} {
+		if schemas, ok := schema[keyword].([]interface{}); ok {
+			for _, subschema := range schemas {
+				downgradeSchema(subschema)
+			}
+		}
+	}
+	for _, keyword := range []string{"items", "additionalProperties", "not"} {
+		downgradeSchema(schema[keyword])
+	}
+}
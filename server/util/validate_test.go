@@ -0,0 +1,88 @@
+package util
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		apidoc   string
+		spec     string
+		problems int
+	}{
+		{`{"swagger": "2.0", "info": {"title": "invoices", "version": "1.0.0"}, "paths": {"/invoices": {"get": {"responses": {"200": {"description": "OK"}}}}}}`, Swagger2, 0},
+		{`{"openapi": "3.0.3", "info": {"title": "invoices", "version": "1.0.0"}, "paths": {"/invoices": {"get": {"responses": {"200": {"description": "OK"}}}}}}`, OpenAPI30, 0},
+		{`{"openapi": "3.1.0", "info": {"title": "invoices", "version": "1.0.0"}, "webhooks": {}}`, OpenAPI31, 0},
+		{`{"swagger": "2.0", "paths": {}}`, Swagger2, 1},
+		{`{"openapi": "3.0.3", "info": {"title": "invoices", "version": "1.0.0"}, "paths": {"invoices": {}}}`, OpenAPI30, 1},
+		{`{"openapi": "4.0.0"}`, "", 1},
+		{`[]`, "", 1},
+	}
+
+	for _, test := range tests {
+		spec, problems := Validate(test.apidoc)
+		if spec != test.spec {
+			t.Errorf("Expected specification %q for %s, got %q", test.spec, test.apidoc, spec)
+		}
+		if len(problems) != test.problems {
+			t.Errorf("Expected %d problems for %s, got %v", test.problems, test.apidoc, problems)
+		}
+	}
+}
+
+func TestValidateProblems(t *testing.T) {
+	// Every part of the document with problems is reported with its location
+	apidoc := `{
+		"openapi": "3.0.3",
+		"info": {"title": "invoices", "version": "1.0.0"},
+		"paths": {
+			"/invoices/{id}": {"get": {"responses": {"200": {"description": "OK"}}}},
+			"/invoices": {"get": {"responses": {"200": {"description": "OK"}}}}
+		},
+		"components": {"schemas": {"Invoice": {"type": "object", "properties": {"id": {"type": "text"}}}, "Amount": {"type": "integer"}}}
+	}`
+	_, problems := Validate(apidoc)
+	if len(problems) != 2 || problems[0].Location != "components.schemas.Invoice" || problems[1].Location != "paths./invoices/{id}" {
+		t.Fatalf("Unexpected problems %v", problems)
+	}
+
+	// The JSON Schema keywords of OpenAPI 3.1 don't hide the other problems in the document
+	apidoc = `{
+		"openapi": "3.1.0",
+		"info": {"title": "invoices", "version": "1.0.0"},
+		"paths": {
+			"/invoices": {"get": {"responses": {"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/Invoice", "description": "The invoice"}}}}}}},
+			"invoices": {}
+		},
+		"components": {"schemas": {"Invoice": {
+			"type": "object",
+			"properties": {
+				"id": {"type": "string", "const": "INV-1234", "examples": ["INV-1234"]},
+				"ref": {"type": ["string", "null"]},
+				"amount": {"type": "integer", "exclusiveMinimum": 0}
+			}
+		}}}
+	}`
+	spec, problems := Validate(apidoc)
+	if spec != OpenAPI31 || len(problems) != 1 || problems[0].Location != "paths.invoices" {
+		t.Fatalf("Unexpected problems %v", problems)
+	}
+}
+
+func TestWriteSwaggerToDiskWithoutInfo(t *testing.T) {
+	store := t.TempDir()
+
+	// A document without info used to panic while determining the title
+	_, err := WriteSwaggerToDisk("invoices", "", `{"swagger": "2.0"}`, "", store, store, Page{Spec: Swagger2, Problems: []ValidationProblem{{Location: "info", Message: "the info object is missing"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	page, _ := ioutil.ReadFile(filepath.Join(store, "invoices.md"))
+	if !strings.Contains(string(page), "title: invoices") || !strings.Contains(string(page), "**Validation problems**") {
+		t.Fatalf("Unexpected page %s", page)
+	}
+}